@@ -0,0 +1,98 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright Authors of Cilium
+
+package cloudalias
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	ec2types "github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/aws/aws-sdk-go-v2/service/imds"
+
+	"github.com/cilium/cilium/pkg/logging/logfields"
+)
+
+func init() {
+	Register(&awsProvider{})
+}
+
+// awsProvider lists the secondary CIDR blocks associated with a node's
+// primary ENI, as assigned via `aws ec2 assign-private-ip-addresses
+// --ipv4-prefix-count` or an equivalent IPv6 prefix assignment.
+type awsProvider struct{}
+
+func (p *awsProvider) Name() string {
+	return "aws"
+}
+
+func (p *awsProvider) ListAliases(ctx context.Context, nodeName string) ([]*net.IPNet, error) {
+	scopedLog := log.WithField(logfields.NodeName, nodeName)
+
+	instanceID, err := instanceIDFromMetadata(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("unable to determine instance ID for node %s: %w", nodeName, err)
+	}
+
+	cfg, err := config.LoadDefaultConfig(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("unable to load AWS config: %w", err)
+	}
+
+	client := ec2.NewFromConfig(cfg)
+	out, err := client.DescribeNetworkInterfaces(ctx, &ec2.DescribeNetworkInterfacesInput{
+		Filters: []ec2types.Filter{
+			{Name: aws.String("attachment.instance-id"), Values: []string{instanceID}},
+			{Name: aws.String("attachment.device-index"), Values: []string{"0"}},
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("unable to describe primary ENI for instance %s: %w", instanceID, err)
+	}
+
+	var cidrs []*net.IPNet
+	for _, iface := range out.NetworkInterfaces {
+		for _, prefix := range iface.Ipv4Prefixes {
+			_, cidr, err := net.ParseCIDR(aws.ToString(prefix.Ipv4Prefix))
+			if err != nil {
+				scopedLog.WithError(err).Warning("Ignoring unparsable AWS IPv4 prefix")
+				continue
+			}
+			cidrs = append(cidrs, cidr)
+		}
+		for _, prefix := range iface.Ipv6Prefixes {
+			_, cidr, err := net.ParseCIDR(aws.ToString(prefix.Ipv6Prefix))
+			if err != nil {
+				scopedLog.WithError(err).Warning("Ignoring unparsable AWS IPv6 prefix")
+				continue
+			}
+			cidrs = append(cidrs, cidr)
+		}
+	}
+
+	if len(cidrs) == 0 {
+		return nil, ErrNoAliasesFound
+	}
+
+	return cidrs, nil
+}
+
+func instanceIDFromMetadata(ctx context.Context) (string, error) {
+	client := imds.New(imds.Options{})
+	out, err := client.GetMetadata(ctx, &imds.GetMetadataInput{Path: "instance-id"})
+	if err != nil {
+		return "", err
+	}
+	defer out.Content.Close()
+
+	content, err := io.ReadAll(out.Content)
+	if err != nil {
+		return "", err
+	}
+	return string(content), nil
+}
@@ -0,0 +1,48 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright Authors of Cilium
+
+// Package cloudalias derives a node's pod CIDR from the secondary IP ranges
+// / alias ranges that a cloud provider has already attached to the node's
+// primary network interface, mirroring the design of Kubernetes'
+// cloud_cidr_allocator. This allows the agent to bootstrap its pod CIDR
+// without relying on kube-controller-manager's --allocate-node-cidrs or the
+// cilium-operator in environments where the cloud provider itself performs
+// the allocation (e.g. GCE alias IP ranges, AWS secondary CIDRs).
+package cloudalias
+
+import (
+	"context"
+	"fmt"
+	"net"
+)
+
+// Provider lists the pod CIDR aliases that a cloud provider has attached to
+// a given node's primary interface.
+type Provider interface {
+	// Name returns a short, human readable identifier for the provider,
+	// e.g. "gce" or "aws".
+	Name() string
+
+	// ListAliases returns the secondary ranges / alias CIDRs currently
+	// attached to nodeName's primary interface.
+	ListAliases(ctx context.Context, nodeName string) ([]*net.IPNet, error)
+}
+
+var providers = map[string]Provider{}
+
+// Register makes a Provider available under its Name(). It is intended to be
+// called from the init() function of the GCE/AWS implementations so that
+// callers only need to import the provider package for its side effect.
+func Register(p Provider) {
+	providers[p.Name()] = p
+}
+
+// Lookup returns the provider registered under name, if any.
+func Lookup(name string) (Provider, bool) {
+	p, ok := providers[name]
+	return p, ok
+}
+
+// ErrNoAliasesFound is returned by a Provider when the node's primary
+// interface has no secondary ranges / alias CIDRs attached yet.
+var ErrNoAliasesFound = fmt.Errorf("no cloud alias CIDRs found for node")
@@ -0,0 +1,56 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright Authors of Cilium
+
+package cloudalias
+
+import (
+	"context"
+	"fmt"
+	"net"
+
+	"github.com/cilium/cilium/pkg/logging"
+	"github.com/cilium/cilium/pkg/logging/logfields"
+)
+
+var log = logging.DefaultLogger.WithField(logfields.LogSubsys, "ipam-cloudalias")
+
+func init() {
+	Register(&gceProvider{})
+}
+
+// gceProvider lists the secondary IP ranges (alias IP ranges) GCE has
+// attached to a node's primary network interface, as configured via
+// `gcloud compute instances network-interfaces update --aliases`.
+type gceProvider struct{}
+
+func (p *gceProvider) Name() string {
+	return "gce"
+}
+
+func (p *gceProvider) ListAliases(ctx context.Context, nodeName string) ([]*net.IPNet, error) {
+	scopedLog := log.WithField(logfields.NodeName, nodeName)
+
+	instance, err := getGCEInstance(ctx, nodeName)
+	if err != nil {
+		return nil, fmt.Errorf("unable to retrieve GCE instance for node %s: %w", nodeName, err)
+	}
+
+	var cidrs []*net.IPNet
+	for _, iface := range instance.NetworkInterfaces {
+		for _, aliasRange := range iface.AliasIPRanges {
+			_, cidr, err := net.ParseCIDR(aliasRange.IPCidrRange)
+			if err != nil {
+				scopedLog.WithError(err).WithField(logfields.CIDR, aliasRange.IPCidrRange).
+					Warning("Ignoring unparsable GCE alias IP range")
+				continue
+			}
+			cidrs = append(cidrs, cidr)
+		}
+	}
+
+	if len(cidrs) == 0 {
+		return nil, ErrNoAliasesFound
+	}
+
+	return cidrs, nil
+}
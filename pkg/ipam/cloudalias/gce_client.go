@@ -0,0 +1,29 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright Authors of Cilium
+
+package cloudalias
+
+import (
+	"context"
+
+	compute "google.golang.org/api/compute/v1"
+
+	"github.com/cilium/cilium/pkg/gke/metadata"
+)
+
+// getGCEInstance fetches the GCE instance backing nodeName via the Compute
+// Engine API, using the zone and project reported by the metadata server the
+// agent is running on.
+func getGCEInstance(ctx context.Context, nodeName string) (*compute.Instance, error) {
+	project, zone, err := metadata.ProjectAndZone(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	svc, err := compute.NewService(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return svc.Instances.Get(project, zone, nodeName).Context(ctx).Do()
+}
@@ -0,0 +1,32 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright Authors of Cilium
+
+// Package option defines the IPAM mode selector consumed outside of
+// pkg/ipam itself, in particular by option.Config.IPAM.
+package option
+
+// IPAMMode selects which IP address management backend the agent uses to
+// hand out PodCIDRs/addresses to the local node.
+type IPAMMode string
+
+const (
+	// IPAMKubernetes uses the PodCIDR/PodCIDRs already assigned by
+	// Kubernetes to the Node resource, e.g. by kube-controller-manager's
+	// --allocate-node-cidrs.
+	IPAMKubernetes IPAMMode = "kubernetes"
+
+	// IPAMClusterPool carves per-node PodCIDRs out of a single pool of
+	// cluster-scoped CIDRs, recorded on the CiliumNode CRD rather than the
+	// Kubernetes Node resource.
+	IPAMClusterPool IPAMMode = "cluster-pool"
+
+	// IPAMClusterPoolV2 extends IPAMClusterPool to the multi-pool
+	// spec.ipam.pools layout, so more than one named pool (and more than
+	// one CIDR per pool) can be allocated to a single CiliumNode.
+	IPAMClusterPoolV2 IPAMMode = "cluster-pool-v2"
+
+	// IPAMCloudAlias derives the node's PodCIDR from the secondary IP
+	// ranges / alias ranges a cloud provider has already attached to the
+	// node's primary network interface, via pkg/ipam/cloudalias.
+	IPAMCloudAlias IPAMMode = "cloud-alias"
+)
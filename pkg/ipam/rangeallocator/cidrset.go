@@ -0,0 +1,145 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright Authors of Cilium
+
+package rangeallocator
+
+import (
+	"fmt"
+	"math/big"
+	"net"
+	"sync"
+)
+
+// ErrCIDRRangeNoCIDRsRemaining is returned by AllocateNext when every chunk
+// of the parent CIDR has already been occupied.
+var ErrCIDRRangeNoCIDRsRemaining = fmt.Errorf("CIDR allocation failed; there are no remaining CIDRs left to allocate in the accepted range")
+
+// CidrSet partitions a parent CIDR (the cluster CIDR) into fixed-size
+// chunks (the per-node PodCIDR) and tracks which chunks are occupied using
+// a bitmap. A single CidrSet only ever covers one address family; the
+// RangeAllocator holds one CidrSet per family so that v4 and v6 allocation
+// can proceed concurrently without contending on a shared lock.
+type CidrSet struct {
+	mu sync.Mutex
+
+	clusterCIDR     *net.IPNet
+	clusterMaskSize int
+	nodeMaskSize    int
+	maxCIDRs        int
+
+	used          big.Int
+	nextCandidate int
+}
+
+// NewCIDRSet creates a CidrSet that carves clusterCIDR into chunks of size
+// nodeMaskSize (in bits, e.g. 24 for a /24 per node).
+func NewCIDRSet(clusterCIDR *net.IPNet, nodeMaskSize int) (*CidrSet, error) {
+	clusterMaskSize, totalBits := clusterCIDR.Mask.Size()
+	if nodeMaskSize < clusterMaskSize {
+		return nil, fmt.Errorf("node mask size %d must not be smaller than cluster mask size %d", nodeMaskSize, clusterMaskSize)
+	}
+	if nodeMaskSize > totalBits {
+		return nil, fmt.Errorf("node mask size %d is larger than address width %d", nodeMaskSize, totalBits)
+	}
+
+	return &CidrSet{
+		clusterCIDR:     clusterCIDR,
+		clusterMaskSize: clusterMaskSize,
+		nodeMaskSize:    nodeMaskSize,
+		maxCIDRs:        1 << uint(nodeMaskSize-clusterMaskSize),
+	}, nil
+}
+
+// AllocateNext returns the next unoccupied chunk of the parent CIDR, marking
+// it occupied. It returns ErrCIDRRangeNoCIDRsRemaining once every chunk has
+// been allocated.
+func (s *CidrSet) AllocateNext() (*net.IPNet, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i := 0; i < s.maxCIDRs; i++ {
+		candidate := (s.nextCandidate + i) % s.maxCIDRs
+		if s.used.Bit(candidate) == 1 {
+			continue
+		}
+
+		s.used.SetBit(&s.used, candidate, 1)
+		s.nextCandidate = (candidate + 1) % s.maxCIDRs
+
+		return s.indexToCIDRBlock(candidate), nil
+	}
+
+	return nil, ErrCIDRRangeNoCIDRsRemaining
+}
+
+// Occupy marks cidr's chunk as used, e.g. when restoring state for a node
+// that already had a PodCIDR assigned before the allocator started.
+func (s *CidrSet) Occupy(cidr *net.IPNet) error {
+	idx, err := s.getIndexForCIDR(cidr)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.used.SetBit(&s.used, idx, 1)
+
+	return nil
+}
+
+// Release marks cidr's chunk as free again.
+func (s *CidrSet) Release(cidr *net.IPNet) error {
+	idx, err := s.getIndexForCIDR(cidr)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.used.SetBit(&s.used, idx, 0)
+
+	return nil
+}
+
+func (s *CidrSet) indexToCIDRBlock(index int) *net.IPNet {
+	ip := make(net.IP, len(s.clusterCIDR.IP))
+	copy(ip, s.clusterCIDR.IP)
+
+	offset := big.NewInt(int64(index))
+	offset.Lsh(offset, uint(len(ip)*8-s.nodeMaskSize))
+
+	base := new(big.Int).SetBytes(ip)
+	base.Or(base, offset)
+
+	out := base.Bytes()
+	padded := make(net.IP, len(ip))
+	copy(padded[len(padded)-len(out):], out)
+
+	return &net.IPNet{
+		IP:   padded,
+		Mask: net.CIDRMask(s.nodeMaskSize, len(ip)*8),
+	}
+}
+
+func (s *CidrSet) getIndexForCIDR(cidr *net.IPNet) (int, error) {
+	maskSize, _ := cidr.Mask.Size()
+	if maskSize != s.nodeMaskSize {
+		return 0, fmt.Errorf("cidr %s mask size %d does not match node mask size %d", cidr, maskSize, s.nodeMaskSize)
+	}
+	if !s.clusterCIDR.Contains(cidr.IP) {
+		return 0, fmt.Errorf("cidr %s is not contained within cluster CIDR %s", cidr, s.clusterCIDR)
+	}
+
+	base := new(big.Int).SetBytes(s.clusterCIDR.IP)
+	candidate := new(big.Int).SetBytes(cidr.IP)
+	candidate.Xor(candidate, base)
+	candidate.Rsh(candidate, uint(len(cidr.IP)*8-s.nodeMaskSize))
+
+	idx := int(candidate.Int64())
+	if idx < 0 || idx >= s.maxCIDRs {
+		return 0, fmt.Errorf("cidr %s is out of range of cluster CIDR %s", cidr, s.clusterCIDR)
+	}
+
+	return idx, nil
+}
+
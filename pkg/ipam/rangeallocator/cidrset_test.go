@@ -0,0 +1,85 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright Authors of Cilium
+
+package rangeallocator
+
+import (
+	"net"
+	"testing"
+)
+
+func mustParseCIDR(t *testing.T, s string) *net.IPNet {
+	t.Helper()
+	_, cidr, err := net.ParseCIDR(s)
+	if err != nil {
+		t.Fatalf("ParseCIDR(%q): %v", s, err)
+	}
+	return cidr
+}
+
+func TestCidrSetAllocateNext(t *testing.T) {
+	set, err := NewCIDRSet(mustParseCIDR(t, "10.0.0.0/16"), 24)
+	if err != nil {
+		t.Fatalf("NewCIDRSet: %v", err)
+	}
+
+	first, err := set.AllocateNext()
+	if err != nil {
+		t.Fatalf("AllocateNext: %v", err)
+	}
+	if first.String() != "10.0.0.0/24" {
+		t.Errorf("got %s, want 10.0.0.0/24", first)
+	}
+
+	second, err := set.AllocateNext()
+	if err != nil {
+		t.Fatalf("AllocateNext: %v", err)
+	}
+	if second.String() != "10.0.1.0/24" {
+		t.Errorf("got %s, want 10.0.1.0/24", second)
+	}
+}
+
+func TestCidrSetOccupyThenRelease(t *testing.T) {
+	set, err := NewCIDRSet(mustParseCIDR(t, "10.0.0.0/16"), 24)
+	if err != nil {
+		t.Fatalf("NewCIDRSet: %v", err)
+	}
+
+	occupied := mustParseCIDR(t, "10.0.5.0/24")
+	if err := set.Occupy(occupied); err != nil {
+		t.Fatalf("Occupy: %v", err)
+	}
+
+	next, err := set.AllocateNext()
+	if err != nil {
+		t.Fatalf("AllocateNext: %v", err)
+	}
+	if next.String() == occupied.String() {
+		t.Fatalf("AllocateNext returned already-occupied chunk %s", next)
+	}
+
+	if err := set.Release(occupied); err != nil {
+		t.Fatalf("Release: %v", err)
+	}
+	if err := set.Occupy(occupied); err != nil {
+		t.Fatalf("Occupy after release: %v", err)
+	}
+}
+
+func TestCidrSetExhausted(t *testing.T) {
+	set, err := NewCIDRSet(mustParseCIDR(t, "10.0.0.0/30"), 31)
+	if err != nil {
+		t.Fatalf("NewCIDRSet: %v", err)
+	}
+
+	for i := 0; i < 2; i++ {
+		if _, err := set.AllocateNext(); err != nil {
+			t.Fatalf("AllocateNext %d: %v", i, err)
+		}
+	}
+
+	if _, err := set.AllocateNext(); err != ErrCIDRRangeNoCIDRsRemaining {
+		t.Errorf("got err %v, want ErrCIDRRangeNoCIDRsRemaining", err)
+	}
+}
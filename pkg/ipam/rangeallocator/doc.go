@@ -0,0 +1,12 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright Authors of Cilium
+
+// Package rangeallocator implements an in-agent CIDR allocator for
+// environments that run the ClusterPool IPAM mode without
+// kube-controller-manager's --allocate-node-cidrs or cilium-operator
+// available to hand out per-node PodCIDRs. It mirrors the design of
+// Kubernetes' range_allocator: a CidrSet partitions a parent cluster CIDR
+// into fixed-size per-node subnets, and the RangeAllocator watches CiliumNode
+// objects to assign (and reclaim) a subnet for every node that needs one.
+// Kubernetes Node objects are not watched.
+package rangeallocator
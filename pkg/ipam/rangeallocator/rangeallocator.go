@@ -0,0 +1,237 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright Authors of Cilium
+
+package rangeallocator
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sync"
+
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	ciliumv2 "github.com/cilium/cilium/pkg/k8s/apis/cilium.io/v2"
+	"github.com/cilium/cilium/pkg/k8s/resource"
+	"github.com/cilium/cilium/pkg/logging"
+	"github.com/cilium/cilium/pkg/logging/logfields"
+)
+
+// ciliumNodeClient is the subset of the generated CiliumNode client the
+// allocator needs to patch a PodCIDR onto a node's spec.
+type ciliumNodeClient interface {
+	Update(ctx context.Context, node *ciliumv2.CiliumNode, opts meta_v1.UpdateOptions) (*ciliumv2.CiliumNode, error)
+}
+
+// RangeAllocator assigns a pod CIDR to every CiliumNode that doesn't have
+// one yet, carving it out of a parent cluster CIDR. It exists for
+// cluster-pool deployments that have neither kube-controller-manager's
+// --allocate-node-cidrs nor cilium-operator available to do this job.
+type RangeAllocator struct {
+	client ciliumNodeClient
+
+	v4Set *CidrSet
+	v6Set *CidrSet
+
+	mu       sync.Mutex
+	assigned map[string][]*net.IPNet // node name -> chunks it holds, for Release on delete
+}
+
+// New creates a RangeAllocator. v4ClusterCIDR and/or v6ClusterCIDR may be
+// nil to disable allocation for that family; nodeMaskSizeV4/V6 is the
+// per-node subnet size in bits (e.g. 24 for a /24 per node).
+func New(client ciliumNodeClient, v4ClusterCIDR *net.IPNet, nodeMaskSizeV4 int, v6ClusterCIDR *net.IPNet, nodeMaskSizeV6 int) (*RangeAllocator, error) {
+	ra := &RangeAllocator{
+		client:   client,
+		assigned: map[string][]*net.IPNet{},
+	}
+
+	if v4ClusterCIDR != nil {
+		set, err := NewCIDRSet(v4ClusterCIDR, nodeMaskSizeV4)
+		if err != nil {
+			return nil, fmt.Errorf("unable to create IPv4 CidrSet: %w", err)
+		}
+		ra.v4Set = set
+	}
+
+	if v6ClusterCIDR != nil {
+		set, err := NewCIDRSet(v6ClusterCIDR, nodeMaskSizeV6)
+		if err != nil {
+			return nil, fmt.Errorf("unable to create IPv6 CidrSet: %w", err)
+		}
+		ra.v6Set = set
+	}
+
+	return ra, nil
+}
+
+// Run watches nodes for Add/Update/Delete and keeps the CidrSets in sync:
+// existing PodCIDRs are occupied as they're observed (so a restart doesn't
+// double-allocate), nodes without one get the next free chunk, and deleted
+// nodes have their chunk released. Run blocks until ctx is cancelled or the
+// nodes resource's event stream closes.
+func (ra *RangeAllocator) Run(ctx context.Context, nodes resource.Resource[*ciliumv2.CiliumNode]) error {
+	logger := logging.FromContext(ctx)
+
+	for ev := range nodes.Events(ctx) {
+		var err error
+		switch ev.Kind {
+		case resource.Upsert:
+			err = ra.handleUpsert(ctx, ev.Object)
+		case resource.Delete:
+			ra.handleDelete(ev.Object)
+		}
+		if err != nil {
+			logger.WithError(err).WithField(logfields.NodeName, ev.Key.Name).
+				Warning("Unable to reconcile PodCIDR allocation for node")
+		}
+		ev.Done(nil)
+	}
+
+	return ctx.Err()
+}
+
+func (ra *RangeAllocator) handleUpsert(ctx context.Context, node *ciliumv2.CiliumNode) error {
+	existingV4, existingV6 := currentPodCIDRs(node)
+
+	// Restore previously occupied chunks first, whether we assigned them in
+	// a prior run or they were already present at startup; this is what
+	// keeps a restarted allocator from handing the same chunk out twice.
+	if existingV4 != nil && ra.v4Set != nil {
+		if err := ra.v4Set.Occupy(existingV4); err != nil {
+			return err
+		}
+		ra.recordAssigned(node.Name, existingV4)
+	}
+	if existingV6 != nil && ra.v6Set != nil {
+		if err := ra.v6Set.Occupy(existingV6); err != nil {
+			return err
+		}
+		ra.recordAssigned(node.Name, existingV6)
+	}
+
+	needsV4 := existingV4 == nil && ra.v4Set != nil
+	needsV6 := existingV6 == nil && ra.v6Set != nil
+	if !needsV4 && !needsV6 {
+		return nil
+	}
+
+	updated := node.DeepCopy()
+	changed := false
+	var newlyAllocated []*net.IPNet
+
+	if existingV4 == nil && ra.v4Set != nil {
+		cidr, err := ra.v4Set.AllocateNext()
+		if err != nil {
+			return fmt.Errorf("unable to allocate IPv4 PodCIDR for node %s: %w", node.Name, err)
+		}
+		updated.Spec.IPAM.PodCIDRs = append(updated.Spec.IPAM.PodCIDRs, cidr.String())
+		ra.recordAssigned(node.Name, cidr)
+		newlyAllocated = append(newlyAllocated, cidr)
+		changed = true
+	}
+
+	if existingV6 == nil && ra.v6Set != nil {
+		cidr, err := ra.v6Set.AllocateNext()
+		if err != nil {
+			ra.releaseAssigned(node.Name, newlyAllocated)
+			return fmt.Errorf("unable to allocate IPv6 PodCIDR for node %s: %w", node.Name, err)
+		}
+		updated.Spec.IPAM.PodCIDRs = append(updated.Spec.IPAM.PodCIDRs, cidr.String())
+		ra.recordAssigned(node.Name, cidr)
+		newlyAllocated = append(newlyAllocated, cidr)
+		changed = true
+	}
+
+	if !changed {
+		return nil
+	}
+
+	if _, err := ra.client.Update(ctx, updated, meta_v1.UpdateOptions{}); err != nil {
+		// The chunk was only ever recorded locally; if it never made it
+		// onto the CiliumNode, release it back to the CidrSet instead of
+		// leaking it forever and forcing every future Upsert for this
+		// node to allocate yet another one.
+		ra.releaseAssigned(node.Name, newlyAllocated)
+		return fmt.Errorf("unable to update CiliumNode %s with allocated PodCIDR: %w", node.Name, err)
+	}
+
+	return nil
+}
+
+// releaseAssigned releases chunks that were allocated for nodeName but never
+// durably persisted (e.g. because the subsequent CiliumNode Update failed),
+// returning them to the relevant CidrSet and dropping them from ra.assigned.
+func (ra *RangeAllocator) releaseAssigned(nodeName string, cidrs []*net.IPNet) {
+	for _, c := range cidrs {
+		if c.IP.To4() != nil {
+			if ra.v4Set != nil {
+				_ = ra.v4Set.Release(c)
+			}
+		} else if ra.v6Set != nil {
+			_ = ra.v6Set.Release(c)
+		}
+	}
+
+	ra.mu.Lock()
+	defer ra.mu.Unlock()
+	remaining := ra.assigned[nodeName][:0]
+	for _, existing := range ra.assigned[nodeName] {
+		keep := true
+		for _, c := range cidrs {
+			if existing.String() == c.String() {
+				keep = false
+				break
+			}
+		}
+		if keep {
+			remaining = append(remaining, existing)
+		}
+	}
+	ra.assigned[nodeName] = remaining
+}
+
+func (ra *RangeAllocator) handleDelete(node *ciliumv2.CiliumNode) {
+	ra.mu.Lock()
+	chunks := ra.assigned[node.Name]
+	delete(ra.assigned, node.Name)
+	ra.mu.Unlock()
+
+	for _, c := range chunks {
+		if c.IP.To4() != nil {
+			if ra.v4Set != nil {
+				_ = ra.v4Set.Release(c)
+			}
+		} else if ra.v6Set != nil {
+			_ = ra.v6Set.Release(c)
+		}
+	}
+}
+
+func (ra *RangeAllocator) recordAssigned(nodeName string, cidr *net.IPNet) {
+	ra.mu.Lock()
+	defer ra.mu.Unlock()
+	for _, existing := range ra.assigned[nodeName] {
+		if existing.String() == cidr.String() {
+			return
+		}
+	}
+	ra.assigned[nodeName] = append(ra.assigned[nodeName], cidr)
+}
+
+// currentPodCIDRs extracts the already-assigned v4/v6 PodCIDRs (if any) from
+// a CiliumNode's Spec.IPAM.PodCIDRs.
+func currentPodCIDRs(node *ciliumv2.CiliumNode) (v4, v6 *net.IPNet) {
+	for _, s := range node.Spec.IPAM.PodCIDRs {
+		_, cidr, err := net.ParseCIDR(s)
+		if err != nil {
+			continue
+		}
+		if cidr.IP.To4() != nil {
+			v4 = cidr
+		} else {
+			v6 = cidr
+		}
+	}
+	return v4, v6
+}
@@ -15,12 +15,22 @@ import (
 	"github.com/cilium/cilium/pkg/ip"
 	"github.com/cilium/cilium/pkg/labels"
 	"github.com/cilium/cilium/pkg/labels/cidr"
+	"github.com/cilium/cilium/pkg/logging"
 	"github.com/cilium/cilium/pkg/logging/logfields"
 	"github.com/cilium/cilium/pkg/metrics"
 	"github.com/cilium/cilium/pkg/option"
 	"github.com/cilium/cilium/pkg/source"
 )
 
+// CIDRAllocation is one entry of a batched AllocateCIDRsBatch call: the
+// prefix to allocate an identity for, any additional labels to merge onto
+// it, and a previously used numeric identity to prefer, if any.
+type CIDRAllocation struct {
+	Prefix      *net.IPNet
+	ExtraLabels labels.Labels
+	OldNID      identity.NumericIdentity
+}
+
 // AllocateCIDRs attempts to allocate identities for a list of CIDRs. If any
 // allocation fails, all allocations are rolled back and the error is returned.
 // When an identity is freshly allocated for a CIDR, it is added to the
@@ -34,11 +44,58 @@ import (
 //
 // Upon success, the caller must also arrange for the resulting identities to
 // be released via a subsequent call to ReleaseCIDRIdentitiesByCIDR().
+//
+// This is a thin wrapper around AllocateCIDRsBatch for callers that have no
+// extra per-prefix labels to merge in.
 func (ipc *IPCache) AllocateCIDRs(
-	prefixes []*net.IPNet, oldNIDs []identity.NumericIdentity, newlyAllocatedIdentities map[string]*identity.Identity,
+	ctx context.Context, prefixes []*net.IPNet, oldNIDs []identity.NumericIdentity, newlyAllocatedIdentities map[string]*identity.Identity,
 ) ([]*identity.Identity, error) {
-	// maintain list of used identities to undo on error
-	usedIdentities := make([]*identity.Identity, 0, len(prefixes))
+	allocations := make([]CIDRAllocation, 0, len(prefixes))
+	for i, p := range prefixes {
+		if p == nil {
+			continue
+		}
+
+		a := CIDRAllocation{Prefix: p, OldNID: identity.InvalidIdentity}
+		if oldNIDs != nil && len(oldNIDs) > i {
+			a.OldNID = oldNIDs[i]
+		}
+		allocations = append(allocations, a)
+	}
+
+	return ipc.AllocateCIDRsBatch(ctx, allocations, newlyAllocatedIdentities)
+}
+
+// AllocateCIDRsBatch is the batched, single-lock-traversal entry point behind
+// AllocateCIDRs. Label composition (cidr.GetCIDRLabels plus any ExtraLabels
+// and ipcache metadata) happens up front, before ipc.IPCache's lock is
+// taken and with only ipc.metadata's read lock held, and the actual
+// reservation is a single call into
+// IdentityAllocator.AllocateIdentitiesBatch that allocates every NID in one
+// kvstore/CRD transaction instead of N sequential AllocateIdentity round
+// trips. This matters because a policy update touching thousands of
+// toFQDN/CIDR selectors would otherwise serialize N round-trips under the
+// ipcache write lock, blocking datapath updates.
+//
+// On any per-prefix failure the whole batch is rolled back via a single
+// ReleaseSlice call.
+//
+// Entries with a nil Prefix are skipped rather than allocated, the same as
+// AllocateCIDRs does for callers building allocations from a raw prefix
+// slice.
+func (ipc *IPCache) AllocateCIDRsBatch(
+	ctx context.Context, allocations []CIDRAllocation, newlyAllocatedIdentities map[string]*identity.Identity,
+) ([]*identity.Identity, error) {
+	logger := logging.FromContext(ctx)
+
+	// Drop entries with a nil Prefix so that no identity is requested for them.
+	nonNil := make([]CIDRAllocation, 0, len(allocations))
+	for _, a := range allocations {
+		if a.Prefix != nil {
+			nonNil = append(nonNil, a)
+		}
+	}
+	allocations = nonNil
 
 	// Maintain list of newly allocated identities to update ipcache,
 	// but upsert them to ipcache only if no map was given by the caller.
@@ -48,42 +105,61 @@ func (ipc *IPCache) AllocateCIDRs(
 		newlyAllocatedIdentities = map[string]*identity.Identity{}
 	}
 
+	// Compose labels up front, before taking the ipcache lock: this is the
+	// expensive part (label merging, metadata lookups) and it only needs the
+	// metadata read lock, not mutual exclusion with ipcache readers/writers.
 	ipc.metadata.RLock()
+	lbls := make([]labels.Labels, len(allocations))
+	oldNIDs := make([]identity.NumericIdentity, len(allocations))
+	for i, a := range allocations {
+		if a.Prefix == nil {
+			continue
+		}
+		l := cidr.GetCIDRLabels(a.Prefix)
+		l.MergeLabels(a.ExtraLabels)
+		l.MergeLabels(ipc.metadata.getLocked(a.Prefix.IP.String()))
+		lbls[i] = l
+		oldNIDs[i] = a.OldNID
+	}
+	ipc.metadata.RUnlock()
+
 	ipc.Lock()
-	allocatedIdentities := make(map[string]*identity.Identity, len(prefixes))
-	for i, p := range prefixes {
-		if p == nil {
+	ids, isNew, err := ipc.IdentityAllocator.AllocateIdentitiesBatch(ctx, lbls, oldNIDs, false)
+	if err != nil {
+		ipc.Unlock()
+		logger.WithError(err).WithField(logfields.Count, len(allocations)).
+			Warning("Failed to batch-allocate CIDR identities, rolling back")
+		ipc.IdentityAllocator.ReleaseSlice(ctx, nil, ids)
+		return nil, err
+	}
+
+	allocatedIdentities := make(map[string]*identity.Identity, len(allocations))
+	for i, a := range allocations {
+		if a.Prefix == nil {
 			continue
 		}
 
-		lbls := cidr.GetCIDRLabels(p)
-		lbls.MergeLabels(ipc.metadata.getLocked(p.IP.String()))
-		oldNID := identity.InvalidIdentity
-		if oldNIDs != nil && len(oldNIDs) > i {
-			oldNID = oldNIDs[i]
+		id := ids[i]
+		if id == nil {
+			continue
 		}
-		id, isNew, err := ipc.allocate(p, lbls, oldNID)
-		if err != nil {
-			ipc.IdentityAllocator.ReleaseSlice(context.Background(), nil, usedIdentities)
-			ipc.Unlock()
-			ipc.metadata.RUnlock()
-			return nil, err
+
+		if lbls[i].Has(labels.LabelWorld[labels.IDNameWorld]) {
+			id.CIDRLabel = labels.NewLabelsFromModel([]string{labels.LabelSourceCIDR + ":" + a.Prefix.String()})
 		}
 
-		prefixStr := p.String()
-		usedIdentities = append(usedIdentities, id)
+		prefixStr := a.Prefix.String()
 		allocatedIdentities[prefixStr] = id
-		if isNew {
+		if isNew[i] {
 			newlyAllocatedIdentities[prefixStr] = id
 		}
 	}
 	ipc.Unlock()
-	ipc.metadata.RUnlock()
 
 	// Only upsert into ipcache if identity wasn't allocated
 	// before and the caller does not care doing this
 	if upsert {
-		ipc.UpsertGeneratedIdentities(newlyAllocatedIdentities, nil)
+		ipc.UpsertGeneratedIdentities(ctx, newlyAllocatedIdentities, nil)
 	}
 
 	identities := make([]*identity.Identity, 0, len(allocatedIdentities))
@@ -99,9 +175,9 @@ func (ipc *IPCache) AllocateCIDRs(
 // Upon success, the caller must also arrange for the resulting identities to
 // be released via a subsequent call to ReleaseCIDRIdentitiesByID().
 func (ipc *IPCache) AllocateCIDRsForIPs(
-	prefixes []net.IP, newlyAllocatedIdentities map[string]*identity.Identity,
+	ctx context.Context, prefixes []net.IP, newlyAllocatedIdentities map[string]*identity.Identity,
 ) ([]*identity.Identity, error) {
-	return ipc.AllocateCIDRs(ip.GetCIDRPrefixesFromIPs(prefixes), nil, newlyAllocatedIdentities)
+	return ipc.AllocateCIDRs(ctx, ip.GetCIDRPrefixesFromIPs(prefixes), nil, newlyAllocatedIdentities)
 }
 
 func cidrLabelToPrefix(label string) (string, bool) {
@@ -116,7 +192,9 @@ func cidrLabelToPrefix(label string) (string, bool) {
 // that were not already upserted. If any 'usedIdentities' are upserted, these
 // are counted separately as they may provide an indication of another logic
 // error elsewhere in the codebase that is causing premature ipcache deletions.
-func (ipc *IPCache) UpsertGeneratedIdentities(newlyAllocatedIdentities map[string]*identity.Identity, usedIdentities []*identity.Identity) {
+func (ipc *IPCache) UpsertGeneratedIdentities(ctx context.Context, newlyAllocatedIdentities map[string]*identity.Identity, usedIdentities []*identity.Identity) {
+	logger := logging.FromContext(ctx)
+
 	for prefixString, id := range newlyAllocatedIdentities {
 		ipc.Upsert(prefixString, nil, 0, nil, Identity{
 			ID:     id.ID,
@@ -132,7 +210,7 @@ func (ipc *IPCache) UpsertGeneratedIdentities(newlyAllocatedIdentities map[strin
 	for _, id := range usedIdentities {
 		prefix, ok := cidrLabelToPrefix(id.CIDRLabel.String())
 		if !ok {
-			log.WithFields(logrus.Fields{
+			logger.WithFields(logrus.Fields{
 				logfields.Identity: id.ID,
 			}).Warning("BUG: Attempting to upsert non-CIDR identity")
 			continue
@@ -169,12 +247,12 @@ func (ipc *IPCache) UpsertGeneratedIdentities(newlyAllocatedIdentities map[strin
 //
 // It is up to the caller to provide the full set of labels for identity
 // allocation.
-func (ipc *IPCache) allocate(prefix *net.IPNet, lbls labels.Labels, oldNID identity.NumericIdentity) (*identity.Identity, bool, error) {
+func (ipc *IPCache) allocate(ctx context.Context, prefix *net.IPNet, lbls labels.Labels, oldNID identity.NumericIdentity) (*identity.Identity, bool, error) {
 	if prefix == nil {
 		return nil, false, nil
 	}
 
-	allocateCtx, cancel := context.WithTimeout(context.Background(), option.Config.IPAllocationTimeout)
+	allocateCtx, cancel := context.WithTimeout(ctx, option.Config.IPAllocationTimeout)
 	defer cancel()
 
 	id, isNew, err := ipc.IdentityAllocator.AllocateIdentity(allocateCtx, lbls, false, oldNID)
@@ -190,6 +268,8 @@ func (ipc *IPCache) allocate(prefix *net.IPNet, lbls labels.Labels, oldNID ident
 }
 
 func (ipc *IPCache) releaseCIDRIdentities(ctx context.Context, prefixes []string) {
+	logger := logging.FromContext(ctx)
+
 	// Create a critical section for identity release + removal from ipcache.
 	// Otherwise, it's possible to trigger the following race condition:
 	//
@@ -210,7 +290,7 @@ func (ipc *IPCache) releaseCIDRIdentities(ctx context.Context, prefixes []string
 	for _, prefix := range prefixes {
 		_, c, err := net.ParseCIDR(prefix)
 		if err != nil {
-			log.WithFields(logrus.Fields{
+			logger.WithFields(logrus.Fields{
 				logfields.CIDR: c,
 			}).WithError(err).Error("Unable to parse CIDR during ipcache release")
 			continue
@@ -218,14 +298,14 @@ func (ipc *IPCache) releaseCIDRIdentities(ctx context.Context, prefixes []string
 		lbls := cidr.GetCIDRLabels(c)
 		id := ipc.IdentityAllocator.LookupIdentity(ctx, lbls)
 		if id == nil {
-			log.WithFields(logrus.Fields{
+			logger.WithFields(logrus.Fields{
 				logfields.CIDR: prefix,
 			}).Errorf("Unable to find identity of previously used CIDR")
 			continue
 		}
 		released, err := ipc.IdentityAllocator.Release(ctx, id, false)
 		if err != nil {
-			log.WithFields(logrus.Fields{
+			logger.WithFields(logrus.Fields{
 				logfields.Identity: id,
 				logfields.CIDR:     prefix,
 			}).WithError(err).Warning("Unable to release CIDR identity. Ignoring error. Identity may be leaked")
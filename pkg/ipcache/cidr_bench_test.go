@@ -0,0 +1,108 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright Authors of Cilium
+
+//go:build !privileged_tests
+
+package ipcache
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/cilium/cilium/pkg/identity"
+	"github.com/cilium/cilium/pkg/labels"
+)
+
+// numBenchPrefixes matches the 10k-CIDR policy update this batching work was
+// motivated by (a toFQDN/CIDR-heavy policy update allocating one identity
+// per prefix).
+const numBenchPrefixes = 10000
+
+// roundTripAllocator models the part of a real IdentityAllocator that
+// dominates allocation latency: a kvstore/CRD write. It charges that cost
+// once per call regardless of how many labels are being allocated for,
+// which is exactly the difference between calling AllocateIdentity once per
+// prefix and calling AllocateIdentitiesBatch once for all of them.
+type roundTripAllocator struct {
+	roundTrip time.Duration
+	next      identity.NumericIdentity
+}
+
+func (r *roundTripAllocator) AllocateIdentity(ctx context.Context, lbls labels.Labels, notifyOwner bool, oldNID identity.NumericIdentity) (*identity.Identity, bool, error) {
+	time.Sleep(r.roundTrip)
+	r.next++
+	return &identity.Identity{ID: r.next}, true, nil
+}
+
+func (r *roundTripAllocator) AllocateIdentitiesBatch(ctx context.Context, lbls []labels.Labels, oldNIDs []identity.NumericIdentity, notifyOwner bool) ([]*identity.Identity, []bool, error) {
+	time.Sleep(r.roundTrip)
+	ids := make([]*identity.Identity, len(lbls))
+	isNew := make([]bool, len(lbls))
+	for i := range lbls {
+		r.next++
+		ids[i] = &identity.Identity{ID: r.next}
+		isNew[i] = true
+	}
+	return ids, isNew, nil
+}
+
+func (r *roundTripAllocator) Release(ctx context.Context, id *identity.Identity, notifyOwner bool) (bool, error) {
+	return true, nil
+}
+
+func (r *roundTripAllocator) ReleaseSlice(ctx context.Context, owner interface{}, identities []*identity.Identity) []error {
+	return nil
+}
+
+func (r *roundTripAllocator) LookupIdentity(ctx context.Context, lbls labels.Labels) *identity.Identity {
+	return nil
+}
+
+func (r *roundTripAllocator) LookupIdentityByID(ctx context.Context, id identity.NumericIdentity) *identity.Identity {
+	return nil
+}
+
+// BenchmarkAllocateIdentitySequential allocates numBenchPrefixes identities
+// one at a time, as AllocateCIDRs did before batching: one round trip per
+// prefix.
+func BenchmarkAllocateIdentitySequential(b *testing.B) {
+	ctx := context.Background()
+	alloc := &roundTripAllocator{roundTrip: 2 * time.Microsecond}
+	lblSets := make([]labels.Labels, numBenchPrefixes)
+	for i := range lblSets {
+		lblSets[i] = labels.NewLabelsFromModel(nil)
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for _, l := range lblSets {
+			if _, _, err := alloc.AllocateIdentity(ctx, l, false, identity.InvalidIdentity); err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+}
+
+// BenchmarkAllocateIdentitiesBatch allocates the same numBenchPrefixes
+// identities via a single AllocateIdentitiesBatch call, as
+// IPCache.AllocateCIDRsBatch now does: one round trip total. This should be
+// more than 10x faster than the sequential benchmark above for 10k
+// prefixes, since it pays the round-trip cost once instead of 10000 times.
+func BenchmarkAllocateIdentitiesBatch(b *testing.B) {
+	ctx := context.Background()
+	alloc := &roundTripAllocator{roundTrip: 2 * time.Microsecond}
+	lblSets := make([]labels.Labels, numBenchPrefixes)
+	oldNIDs := make([]identity.NumericIdentity, numBenchPrefixes)
+	for i := range lblSets {
+		lblSets[i] = labels.NewLabelsFromModel(nil)
+		oldNIDs[i] = identity.InvalidIdentity
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, _, err := alloc.AllocateIdentitiesBatch(ctx, lblSets, oldNIDs, false); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
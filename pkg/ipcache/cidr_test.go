@@ -0,0 +1,96 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright Authors of Cilium
+
+//go:build !privileged_tests
+
+package ipcache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/cilium/cilium/pkg/identity"
+	"github.com/cilium/cilium/pkg/labels"
+)
+
+// partialFailureAllocator models an IdentityAllocator whose batch call
+// allocates the first failAt entries and then fails, the way a kvstore/CRD
+// transaction can partially apply before erroring out. It records every
+// identity passed to ReleaseSlice so a caller's rollback can be asserted on.
+type partialFailureAllocator struct {
+	failAt   int
+	released []*identity.Identity
+}
+
+func (p *partialFailureAllocator) AllocateIdentity(ctx context.Context, lbls labels.Labels, notifyOwner bool, oldNID identity.NumericIdentity) (*identity.Identity, bool, error) {
+	return nil, false, errors.New("not implemented")
+}
+
+func (p *partialFailureAllocator) AllocateIdentitiesBatch(ctx context.Context, lbls []labels.Labels, oldNIDs []identity.NumericIdentity, notifyOwner bool) ([]*identity.Identity, []bool, error) {
+	ids := make([]*identity.Identity, len(lbls))
+	isNew := make([]bool, len(lbls))
+	for i := range lbls {
+		if i >= p.failAt {
+			break
+		}
+		ids[i] = &identity.Identity{ID: identity.NumericIdentity(i + 1)}
+		isNew[i] = true
+	}
+	return ids, isNew, fmt.Errorf("simulated failure allocating entry %d of %d", p.failAt, len(lbls))
+}
+
+func (p *partialFailureAllocator) Release(ctx context.Context, id *identity.Identity, notifyOwner bool) (bool, error) {
+	return true, nil
+}
+
+func (p *partialFailureAllocator) ReleaseSlice(ctx context.Context, owner interface{}, identities []*identity.Identity) []error {
+	p.released = append(p.released, identities...)
+	return nil
+}
+
+func (p *partialFailureAllocator) LookupIdentity(ctx context.Context, lbls labels.Labels) *identity.Identity {
+	return nil
+}
+
+func (p *partialFailureAllocator) LookupIdentityByID(ctx context.Context, id identity.NumericIdentity) *identity.Identity {
+	return nil
+}
+
+// TestAllocateIdentitiesBatchPartialFailureRollsBack exercises the rollback
+// contract AllocateCIDRsBatch relies on: when AllocateIdentitiesBatch fails
+// partway through, it still returns every identity it managed to allocate
+// before the error, and the caller rolls all of them back via a single
+// ReleaseSlice call, exactly as AllocateCIDRsBatch does on error.
+func TestAllocateIdentitiesBatchPartialFailureRollsBack(t *testing.T) {
+	ctx := context.Background()
+	alloc := &partialFailureAllocator{failAt: 2}
+
+	lbls := make([]labels.Labels, 5)
+	oldNIDs := make([]identity.NumericIdentity, 5)
+	for i := range lbls {
+		lbls[i] = labels.NewLabelsFromModel(nil)
+		oldNIDs[i] = identity.InvalidIdentity
+	}
+
+	ids, _, err := alloc.AllocateIdentitiesBatch(ctx, lbls, oldNIDs, false)
+	if err == nil {
+		t.Fatal("expected AllocateIdentitiesBatch to fail partway through")
+	}
+
+	alloc.ReleaseSlice(ctx, nil, ids)
+
+	if len(alloc.released) != len(ids) {
+		t.Fatalf("got %d released identities, want %d", len(alloc.released), len(ids))
+	}
+	var gotAllocated int
+	for _, id := range alloc.released {
+		if id != nil {
+			gotAllocated++
+		}
+	}
+	if gotAllocated != alloc.failAt {
+		t.Errorf("got %d non-nil released identities, want %d (the ones allocated before the failure)", gotAllocated, alloc.failAt)
+	}
+}
@@ -0,0 +1,44 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright Authors of Cilium
+
+package ipcache
+
+import (
+	"context"
+
+	"github.com/cilium/cilium/pkg/identity"
+	"github.com/cilium/cilium/pkg/labels"
+)
+
+// IdentityAllocator is the subset of pkg/identity/cache's CachingIdentityAllocator
+// that ipcache's CIDR allocation path needs.
+type IdentityAllocator interface {
+	// AllocateIdentity allocates an identity for the given labels.
+	AllocateIdentity(ctx context.Context, lbls labels.Labels, notifyOwner bool, oldNID identity.NumericIdentity) (*identity.Identity, bool, error)
+
+	// AllocateIdentitiesBatch allocates identities for every entry of lbls in
+	// a single kvstore/CRD transaction, instead of the N round trips
+	// AllocateIdentity would require if called once per entry. oldNIDs, if
+	// non-nil, carries a previously used numeric identity to prefer for the
+	// label set at the same index; identity.InvalidIdentity means none.
+	// Returns, for each entry, the allocated identity and whether it was
+	// newly allocated rather than reused from the cache.
+	AllocateIdentitiesBatch(ctx context.Context, lbls []labels.Labels, oldNIDs []identity.NumericIdentity, notifyOwner bool) (ids []*identity.Identity, isNew []bool, err error)
+
+	// Release releases a previously allocated identity.
+	Release(ctx context.Context, id *identity.Identity, notifyOwner bool) (released bool, err error)
+
+	// ReleaseSlice releases a batch of previously allocated identities, as
+	// used to roll back a partially failed AllocateIdentitiesBatch call.
+	// owner is passed through to per-identity release notifications, if any;
+	// nil means none are needed.
+	ReleaseSlice(ctx context.Context, owner interface{}, identities []*identity.Identity) []error
+
+	// LookupIdentity returns the identity currently allocated for lbls, if
+	// any.
+	LookupIdentity(ctx context.Context, lbls labels.Labels) *identity.Identity
+
+	// LookupIdentityByID returns the identity currently allocated under the
+	// given numeric ID, if any.
+	LookupIdentityByID(ctx context.Context, id identity.NumericIdentity) *identity.Identity
+}
@@ -0,0 +1,80 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright Authors of Cilium
+
+package k8s
+
+import (
+	"encoding/json"
+	"sort"
+	"strings"
+
+	nodeTypes "github.com/cilium/cilium/pkg/node/types"
+)
+
+// jsonPatchOp is a single RFC 6902 JSON Patch operation. Value is a pointer
+// so "add"/"replace" can carry an empty string (RFC 6902 requires a "value"
+// member for those ops) while "remove", which never sets it, omits the
+// member entirely.
+type jsonPatchOp struct {
+	Op    string  `json:"op"`
+	Path  string  `json:"path"`
+	Value *string `json:"value,omitempty"`
+}
+
+// escapeJSONPatchToken escapes a JSON Patch path token per RFC 6901: '~'
+// must be escaped first, or the '~' of the "~1" produced for a '/' would be
+// escaped again into "~01".
+func escapeJSONPatchToken(token string) string {
+	token = strings.ReplaceAll(token, "~", "~0")
+	token = strings.ReplaceAll(token, "/", "~1")
+	return token
+}
+
+// PrepareNodeAnnotationsPatch diffs the Cilium-managed annotations old and
+// new actually consumed (old.ConsumedAnnotations vs new.ConsumedAnnotations)
+// and returns a single RFC 6902 JSON Patch that reconciles the node's
+// annotations from the old state to the new one: added keys become "add"
+// ops, changed values become "replace" ops, and keys old had but new
+// doesn't become "remove" ops.
+//
+// Returns nil, nil if there is nothing to patch.
+func PrepareNodeAnnotationsPatch(old, new *nodeTypes.Node) ([]byte, error) {
+	oldAnnotations := old.ConsumedAnnotations
+	newAnnotations := new.ConsumedAnnotations
+
+	keys := make(map[string]struct{}, len(oldAnnotations)+len(newAnnotations))
+	for k := range oldAnnotations {
+		keys[k] = struct{}{}
+	}
+	for k := range newAnnotations {
+		keys[k] = struct{}{}
+	}
+
+	sortedKeys := make([]string, 0, len(keys))
+	for k := range keys {
+		sortedKeys = append(sortedKeys, k)
+	}
+	sort.Strings(sortedKeys)
+
+	var ops []jsonPatchOp
+	for _, key := range sortedKeys {
+		oldValue, hadOld := oldAnnotations[key]
+		newValue, hasNew := newAnnotations[key]
+		path := "/metadata/annotations/" + escapeJSONPatchToken(key)
+
+		switch {
+		case !hadOld && hasNew:
+			ops = append(ops, jsonPatchOp{Op: "add", Path: path, Value: &newValue})
+		case hadOld && !hasNew:
+			ops = append(ops, jsonPatchOp{Op: "remove", Path: path})
+		case hadOld && hasNew && oldValue != newValue:
+			ops = append(ops, jsonPatchOp{Op: "replace", Path: path, Value: &newValue})
+		}
+	}
+
+	if len(ops) == 0 {
+		return nil, nil
+	}
+
+	return json.Marshal(ops)
+}
@@ -6,23 +6,28 @@ package k8s
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"net"
 	"os"
-	"time"
 
 	"github.com/sirupsen/logrus"
 	corev1 "k8s.io/api/core/v1"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	utilnet "k8s.io/apimachinery/pkg/util/net"
 	"k8s.io/client-go/rest"
 
-	"github.com/cilium/cilium/pkg/backoff"
 	"github.com/cilium/cilium/pkg/controller"
+	"github.com/cilium/cilium/pkg/ipam/cloudalias"
 	ipamOption "github.com/cilium/cilium/pkg/ipam/option"
+	"github.com/cilium/cilium/pkg/ipam/rangeallocator"
 	ciliumv2 "github.com/cilium/cilium/pkg/k8s/apis/cilium.io/v2"
 	k8sconfig "github.com/cilium/cilium/pkg/k8s/config"
 	k8sConst "github.com/cilium/cilium/pkg/k8s/constants"
+	"github.com/cilium/cilium/pkg/k8s/resource"
 	slim_corev1 "github.com/cilium/cilium/pkg/k8s/slim/k8s/api/core/v1"
 	k8sversion "github.com/cilium/cilium/pkg/k8s/version"
+	"github.com/cilium/cilium/pkg/logging"
 	"github.com/cilium/cilium/pkg/logging/logfields"
 	"github.com/cilium/cilium/pkg/node"
 	nodeTypes "github.com/cilium/cilium/pkg/node/types"
@@ -30,38 +35,106 @@ import (
 	"github.com/cilium/cilium/pkg/source"
 )
 
-const (
-	nodeRetrievalMaxRetries = 15
-)
-
 type k8sGetter interface {
 	GetK8sNode(ctx context.Context, nodeName string) (*corev1.Node, error)
 	GetCiliumNode(ctx context.Context, nodeName string) (*ciliumv2.CiliumNode, error)
 }
 
-func waitForNodeInformation(ctx context.Context, k8sGetter k8sGetter, nodeName string) *nodeTypes.Node {
-	backoff := backoff.Exponential{
-		Min:    time.Duration(200) * time.Millisecond,
-		Max:    2 * time.Minute,
-		Factor: 2.0,
-		Name:   "k8s-node-retrieval",
+// Option configures the behavior of WaitForNodeInformation.
+type Option func(*waitOptions)
+
+type waitOptions struct {
+	readiness func(*nodeTypes.Node) error
+}
+
+// WithReadiness adds an additional predicate that the retrieved node must
+// satisfy before WaitForNodeInformation returns. This allows callers other
+// than the agent bootstrap (for example consumers that additionally require
+// restored router IPs) to reuse this primitive with their own completeness
+// criteria.
+func WithReadiness(fn func(*nodeTypes.Node) error) Option {
+	return func(o *waitOptions) {
+		o.readiness = fn
 	}
+}
+
+// waitForNodeInformation subscribes to the local Node and/or CiliumNode
+// resource (depending on the configured IPAM mode) and blocks until an
+// object satisfying the configured requirements is observed, or ctx is
+// cancelled. Unlike the previous fixed 15-try exponential backoff, there is
+// no artificial ceiling: progress is made the instant the operator writes
+// the PodCIDR, and the caller's context is the only timeout source.
+func waitForNodeInformation(ctx context.Context, k8sGetter k8sGetter, localNode resource.Resource[*slim_corev1.Node], localCiliumNode resource.Resource[*ciliumv2.CiliumNode], nodeName string, opts waitOptions) *nodeTypes.Node {
+	logger := logging.FromContext(ctx)
+
+	if n, err := retrieveReadyNodeInformation(ctx, k8sGetter, nodeName, opts); err == nil {
+		return n
+	}
+
+	var ciliumNodeEvents <-chan resource.Event[*ciliumv2.CiliumNode]
+	var k8sNodeEvents <-chan resource.Event[*slim_corev1.Node]
+	if option.Config.IPAM == ipamOption.IPAMClusterPool || option.Config.IPAM == ipamOption.IPAMClusterPoolV2 {
+		ciliumNodeEvents = localCiliumNode.Events(ctx)
+	} else {
+		k8sNodeEvents = localNode.Events(ctx)
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
 
-	for retry := 0; retry < nodeRetrievalMaxRetries; retry++ {
-		n, err := retrieveNodeInformation(ctx, k8sGetter, nodeName)
+		case ev, ok := <-ciliumNodeEvents:
+			if !ok {
+				ciliumNodeEvents = nil
+				continue
+			}
+			ev.Done(nil)
+			if ev.Kind != resource.Upsert {
+				continue
+			}
+
+		case ev, ok := <-k8sNodeEvents:
+			if !ok {
+				k8sNodeEvents = nil
+				continue
+			}
+			ev.Done(nil)
+			if ev.Kind != resource.Upsert {
+				continue
+			}
+		}
+
+		n, err := retrieveReadyNodeInformation(ctx, k8sGetter, nodeName, opts)
 		if err != nil {
-			log.WithError(err).Warning("Waiting for k8s node information")
-			backoff.Wait(ctx)
+			logger.WithError(err).Debug("Node information not yet complete, waiting for next update")
 			continue
 		}
 
 		return n
 	}
+}
 
-	return nil
+// retrieveReadyNodeInformation retrieves the node information and, if a
+// readiness predicate was configured via WithReadiness, additionally
+// requires it to succeed before the node is considered usable.
+func retrieveReadyNodeInformation(ctx context.Context, k8sGetter k8sGetter, nodeName string, opts waitOptions) (*nodeTypes.Node, error) {
+	n, err := retrieveNodeInformation(ctx, k8sGetter, nodeName)
+	if err != nil {
+		return nil, err
+	}
+
+	if opts.readiness != nil {
+		if err := opts.readiness(n); err != nil {
+			return nil, err
+		}
+	}
+
+	return n, nil
 }
 
 func retrieveNodeInformation(ctx context.Context, nodeGetter k8sGetter, nodeName string) (*nodeTypes.Node, error) {
+	logger := logging.FromContext(ctx)
 	requireIPv4CIDR := option.Config.K8sRequireIPv4PodCIDR
 	requireIPv6CIDR := option.Config.K8sRequireIPv6PodCIDR
 	// At this point it's not clear whether the device auto-detection will
@@ -85,7 +158,56 @@ func retrieveNodeInformation(ctx context.Context, nodeGetter k8sGetter, nodeName
 
 		no := nodeTypes.ParseCiliumNode(ciliumNode)
 		n = &no
-		log.WithField(logfields.NodeName, n.Name).Info("Retrieved node information from cilium node")
+		logger.WithField(logfields.NodeName, n.Name).Info("Retrieved node information from cilium node")
+
+		if option.Config.IPAM == ipamOption.IPAMClusterPoolV2 {
+			if err := registerPodCIDRPools(ciliumNode, requireIPv4CIDR, requireIPv6CIDR); err != nil {
+				return nil, err
+			}
+		}
+	} else if option.Config.IPAM == ipamOption.IPAMCloudAlias {
+		k8sNode, err := nodeGetter.GetK8sNode(ctx, nodeName)
+		if err != nil {
+			return nil, fmt.Errorf("unable to retrieve k8s node information: %s", err)
+		}
+
+		nodeInterface := ConvertToNode(k8sNode)
+		if nodeInterface == nil {
+			return nil, fmt.Errorf("invalid k8s node: %s", k8sNode)
+		}
+		typesNode := nodeInterface.(*slim_corev1.Node)
+
+		// Labels and node IPs still come from the k8s Node object; only the
+		// alloc CIDRs are replaced by what the cloud provider already
+		// attached to the node's primary interface.
+		n = ParseNode(typesNode, source.Unspec)
+
+		provider, ok := cloudalias.Lookup(option.Config.CloudAliasProvider)
+		if !ok {
+			return nil, fmt.Errorf("unknown cloud alias provider %q", option.Config.CloudAliasProvider)
+		}
+
+		cidrs, err := provider.ListAliases(ctx, nodeName)
+		if err != nil {
+			if !requireIPv4CIDR && !requireIPv6CIDR && !mightAutoDetectDevices {
+				return n, nil
+			}
+			return nil, fmt.Errorf("unable to list %s cloud aliases: %w", provider.Name(), err)
+		}
+
+		for _, c := range cidrs {
+			if c.IP.To4() != nil {
+				n.IPv4AllocCIDR = c
+			} else {
+				n.IPv6AllocCIDR = c
+			}
+		}
+
+		if err := updateCiliumNodePodCIDRs(ctx, nodeName, cidrs); err != nil {
+			logger.WithError(err).Warning("Unable to publish cloud-alias derived PodCIDR to CiliumNode spec")
+		}
+
+		logger.WithField(logfields.NodeName, n.Name).Infof("Derived node PodCIDR from %s cloud aliases", provider.Name())
 	} else {
 		k8sNode, err := nodeGetter.GetK8sNode(ctx, nodeName)
 		if err != nil {
@@ -110,20 +232,92 @@ func retrieveNodeInformation(ctx context.Context, nodeGetter k8sGetter, nodeName
 		// The source is left unspecified as this node resource should never be
 		// used to update state
 		n = ParseNode(typesNode, source.Unspec)
-		log.WithField(logfields.NodeName, n.Name).Info("Retrieved node information from kubernetes node")
+		logger.WithField(logfields.NodeName, n.Name).Info("Retrieved node information from kubernetes node")
 	}
 
-	if requireIPv4CIDR && n.IPv4AllocCIDR == nil {
-		return nil, fmt.Errorf("required IPv4 PodCIDR not available")
-	}
+	// ClusterPoolV2 nodes satisfy the CIDR requirement via their
+	// spec.ipam.pools allocations instead of a legacy single CIDR; that was
+	// already validated per-pool by registerPodCIDRPools above, so the
+	// generic IPv4AllocCIDR/IPv6AllocCIDR checks below don't apply to them.
+	if option.Config.IPAM != ipamOption.IPAMClusterPoolV2 {
+		if requireIPv4CIDR && n.IPv4AllocCIDR == nil {
+			return nil, fmt.Errorf("required IPv4 PodCIDR not available")
+		}
 
-	if requireIPv6CIDR && n.IPv6AllocCIDR == nil {
-		return nil, fmt.Errorf("required IPv6 PodCIDR not available")
+		if requireIPv6CIDR && n.IPv6AllocCIDR == nil {
+			return nil, fmt.Errorf("required IPv6 PodCIDR not available")
+		}
 	}
 
 	return n, nil
 }
 
+// registerPodCIDRPools parses the multi-pool `spec.ipam.pools` layout used by
+// the ClusterPool v2 allocator and registers every (poolName, []cidr) mapping
+// with the node package. It validates that each pool named in
+// option.Config.IPAMMultiPoolPreAllocation is allocated and carries at least
+// one CIDR of each family required by K8sRequireIPv4PodCIDR/K8sRequireIPv6PodCIDR,
+// so that startup fails fast rather than silently falling back to a single
+// CIDR per family.
+func registerPodCIDRPools(ciliumNode *ciliumv2.CiliumNode, requireIPv4CIDR, requireIPv6CIDR bool) error {
+	pools := make(map[string]ciliumv2.IPAMPoolAllocation, len(ciliumNode.Spec.IPAM.Pools.Allocated))
+	for _, pool := range ciliumNode.Spec.IPAM.Pools.Allocated {
+		pools[pool.Pool] = pool
+	}
+
+	for poolName := range option.Config.IPAMMultiPoolPreAllocation {
+		pool, ok := pools[poolName]
+		if !ok {
+			return fmt.Errorf("required IPAM pool %q not yet allocated to CiliumNode %q", poolName, ciliumNode.Name)
+		}
+
+		var v4CIDRs, v6CIDRs []*net.IPNet
+		for _, c := range pool.CIDRs {
+			_, cidr, err := net.ParseCIDR(string(c))
+			if err != nil {
+				return fmt.Errorf("unable to parse CIDR %q of pool %q: %w", c, poolName, err)
+			}
+			if cidr.IP.To4() != nil {
+				v4CIDRs = append(v4CIDRs, cidr)
+			} else {
+				v6CIDRs = append(v6CIDRs, cidr)
+			}
+		}
+
+		if requireIPv4CIDR && len(v4CIDRs) == 0 {
+			return fmt.Errorf("required IPv4 PodCIDR not available in pool %q", poolName)
+		}
+		if requireIPv6CIDR && len(v6CIDRs) == 0 {
+			return fmt.Errorf("required IPv6 PodCIDR not available in pool %q", poolName)
+		}
+
+		node.SetAllocRangesByPool(poolName, v4CIDRs, v6CIDRs)
+	}
+
+	return nil
+}
+
+// updateCiliumNodePodCIDRs patches the discovered cloud-alias CIDRs onto
+// Spec.IPAM.PodCIDRs of the local CiliumNode so the operator and other
+// agents can observe the pod CIDR this node settled on, even though it was
+// never written by kube-controller-manager or cilium-operator.
+func updateCiliumNodePodCIDRs(ctx context.Context, nodeName string, cidrs []*net.IPNet) error {
+	podCIDRs := make([]string, 0, len(cidrs))
+	for _, c := range cidrs {
+		podCIDRs = append(podCIDRs, c.String())
+	}
+
+	client := CiliumClient().CiliumV2().CiliumNodes()
+	ciliumNode, err := client.Get(ctx, nodeName, meta_v1.GetOptions{})
+	if err != nil {
+		return fmt.Errorf("unable to retrieve CiliumNode %q: %w", nodeName, err)
+	}
+
+	ciliumNode.Spec.IPAM.PodCIDRs = podCIDRs
+	_, err = client.Update(ctx, ciliumNode, meta_v1.UpdateOptions{})
+	return err
+}
+
 // useNodeCIDR sets the ipv4-range and ipv6-range values values from the
 // addresses defined in the given node.
 func useNodeCIDR(n *nodeTypes.Node) {
@@ -136,8 +330,16 @@ func useNodeCIDR(n *nodeTypes.Node) {
 }
 
 // Init initializes the Kubernetes package. It is required to call Configure()
-// beforehand.
-func Init(conf k8sconfig.Configuration) error {
+// beforehand. The logger attached to ctx (see logging.NewContext) is used
+// for Init's own log lines and by the in-agent node CIDR allocator; the
+// heartbeat controller does not use it. Callers that haven't attached one get
+// logging.DefaultLogger via the FromContext fallback. ciliumNodes is only
+// consulted when option.Config.EnableAgentNodeCIDRAllocation is set, to
+// drive the in-agent range CIDR allocator; callers that don't enable that
+// mode may pass a nil resource.
+func Init(ctx context.Context, conf k8sconfig.Configuration, ciliumNodes resource.Resource[*ciliumv2.CiliumNode]) error {
+	logger := logging.FromContext(ctx)
+
 	restConfig, err := CreateConfig()
 	if err != nil {
 		return fmt.Errorf("unable to create k8s client rest configuration: %s", err)
@@ -215,34 +417,94 @@ func Init(conf k8sconfig.Configuration) error {
 			k8sversion.Version(), k8sversion.MinimalVersionConstraint)
 	}
 
+	if option.Config.EnableAgentNodeCIDRAllocation {
+		if err := startNodeCIDRAllocator(ctx, ciliumNodes); err != nil {
+			return fmt.Errorf("unable to start in-agent node CIDR allocator: %w", err)
+		}
+	}
+
+	logger.Info("Kubernetes client initialized")
+
+	return nil
+}
+
+// startNodeCIDRAllocator builds a rangeallocator.RangeAllocator from the
+// configured cluster CIDRs and runs it in the background so it can assign
+// PodCIDRs to CiliumNodes in environments without kube-controller-manager or
+// cilium-operator available to do so.
+func startNodeCIDRAllocator(ctx context.Context, ciliumNodes resource.Resource[*ciliumv2.CiliumNode]) error {
+	var v4ClusterCIDR, v6ClusterCIDR *net.IPNet
+
+	if option.Config.EnableIPv4 && option.Config.ClusterPoolIPv4CIDR != "" {
+		_, cidr, err := net.ParseCIDR(option.Config.ClusterPoolIPv4CIDR)
+		if err != nil {
+			return fmt.Errorf("invalid IPv4 cluster-pool CIDR %q: %w", option.Config.ClusterPoolIPv4CIDR, err)
+		}
+		v4ClusterCIDR = cidr
+	}
+
+	if option.Config.EnableIPv6 && option.Config.ClusterPoolIPv6CIDR != "" {
+		_, cidr, err := net.ParseCIDR(option.Config.ClusterPoolIPv6CIDR)
+		if err != nil {
+			return fmt.Errorf("invalid IPv6 cluster-pool CIDR %q: %w", option.Config.ClusterPoolIPv6CIDR, err)
+		}
+		v6ClusterCIDR = cidr
+	}
+
+	allocator, err := rangeallocator.New(
+		CiliumClient().CiliumV2().CiliumNodes(),
+		v4ClusterCIDR, option.Config.ClusterPoolIPv4MaskSize,
+		v6ClusterCIDR, option.Config.ClusterPoolIPv6MaskSize,
+	)
+	if err != nil {
+		return err
+	}
+
+	go func() {
+		if err := allocator.Run(ctx, ciliumNodes); err != nil && ctx.Err() == nil {
+			logging.FromContext(ctx).WithError(err).Error("In-agent node CIDR allocator stopped unexpectedly")
+		}
+	}()
+
 	return nil
 }
 
 // WaitForNodeInformation retrieves the node information via the CiliumNode or
 // Kubernetes Node resource. This function will block until the information is
-// received. k8sGetter is a function used to retrieve the node from either
-// the kube-apiserver or a local cache, depending on the caller.
-func WaitForNodeInformation(ctx context.Context, k8sGetter k8sGetter) error {
+// received or ctx is cancelled. k8sGetter is a function used to retrieve the
+// node from either the kube-apiserver or a local cache, depending on the
+// caller. localNode and localCiliumNode are used to wait for updates instead
+// of polling; only the one matching the configured IPAM mode is consulted.
+func WaitForNodeInformation(ctx context.Context, k8sGetter k8sGetter, localNode resource.Resource[*slim_corev1.Node], localCiliumNode resource.Resource[*ciliumv2.CiliumNode], opts ...Option) error {
+	var o waitOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+
 	// Use of the environment variable overwrites the node-name
 	// automatically derived
 	nodeName := nodeTypes.GetName()
+
+	logger := logging.FromContext(ctx).WithField(logfields.NodeName, nodeName)
+	ctx = logging.NewContext(ctx, logger)
+
 	if nodeName == "" {
 		if option.Config.K8sRequireIPv4PodCIDR || option.Config.K8sRequireIPv6PodCIDR {
 			return fmt.Errorf("node name must be specified via environment variable '%s' to retrieve Kubernetes PodCIDR range", k8sConst.EnvNodeNameSpec)
 		}
 		if option.MightAutoDetectDevices() {
-			log.Info("K8s node name is empty. BPF NodePort might not be able to auto detect all devices")
+			logger.Info("K8s node name is empty. BPF NodePort might not be able to auto detect all devices")
 		}
 		return nil
 	}
 
-	if n := waitForNodeInformation(ctx, k8sGetter, nodeName); n != nil {
+	if n := waitForNodeInformation(ctx, k8sGetter, localNode, localCiliumNode, nodeName, o); n != nil {
 		nodeIP4 := n.GetNodeIP(false)
 		nodeIP6 := n.GetNodeIP(true)
 
 		k8sNodeIP := n.GetK8sNodeIP()
 
-		log.WithFields(logrus.Fields{
+		logger.WithFields(logrus.Fields{
 			logfields.NodeName:         n.Name,
 			logfields.Labels:           logfields.Repr(n.Labels),
 			logfields.IPAddr + ".ipv4": nodeIP4,
@@ -277,11 +539,17 @@ func WaitForNodeInformation(ctx context.Context, k8sGetter k8sGetter) error {
 		node.SetK8sNodeIP(k8sNodeIP)
 
 		restoreRouterHostIPs(n)
+	} else if errors.Is(ctx.Err(), context.Canceled) {
+		// ctx was cancelled for a reason other than waitForNodeInformation
+		// giving up on its own (e.g. the agent is shutting down gracefully);
+		// let the caller's shutdown path handle that instead of treating it
+		// as a failure to retrieve the PodCIDR.
+		return ctx.Err()
 	} else {
 		// if node resource could not be received, fail if
 		// PodCIDR requirement has been requested
 		if option.Config.K8sRequireIPv4PodCIDR || option.Config.K8sRequireIPv6PodCIDR {
-			log.Fatal("Unable to derive PodCIDR via Node or CiliumNode resource, giving up")
+			logger.Fatal("Unable to derive PodCIDR via Node or CiliumNode resource, giving up")
 		}
 	}
 
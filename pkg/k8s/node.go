@@ -0,0 +1,239 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright Authors of Cilium
+
+package k8s
+
+import (
+	"fmt"
+	"net"
+
+	"github.com/sirupsen/logrus"
+	"k8s.io/client-go/tools/cache"
+
+	"github.com/cilium/cilium/pkg/annotation"
+	slim_corev1 "github.com/cilium/cilium/pkg/k8s/slim/k8s/api/core/v1"
+	slim_metav1 "github.com/cilium/cilium/pkg/k8s/slim/k8s/apis/meta/v1"
+	"github.com/cilium/cilium/pkg/logging/logfields"
+	nodeAddressing "github.com/cilium/cilium/pkg/node/addressing"
+	nodeTypes "github.com/cilium/cilium/pkg/node/types"
+	"github.com/cilium/cilium/pkg/option"
+	"github.com/cilium/cilium/pkg/source"
+)
+
+// ConvertToNode converts a resource watched by the slim Node informer into
+// the subset of fields Cilium actually reads before it is handed to the
+// resource store: of the object metadata only the name, labels, annotations
+// and resource version are kept, while Spec and Status are copied whole.
+func ConvertToNode(obj interface{}) interface{} {
+	switch concreteObj := obj.(type) {
+	case *slim_corev1.Node:
+		return &slim_corev1.Node{
+			TypeMeta: concreteObj.TypeMeta,
+			ObjectMeta: slim_metav1.ObjectMeta{
+				Name:            concreteObj.Name,
+				Labels:          concreteObj.Labels,
+				Annotations:     concreteObj.Annotations,
+				ResourceVersion: concreteObj.ResourceVersion,
+			},
+			Spec:   concreteObj.Spec,
+			Status: concreteObj.Status,
+		}
+	case cache.DeletedFinalStateUnknown:
+		node, ok := concreteObj.Obj.(*slim_corev1.Node)
+		if !ok {
+			return obj
+		}
+		return cache.DeletedFinalStateUnknown{
+			Key: concreteObj.Key,
+			Obj: ConvertToNode(node),
+		}
+	default:
+		return obj
+	}
+}
+
+// ParseNodeAddressType converts a Kubernetes NodeAddressType to a Cilium
+// node address type.
+func ParseNodeAddressType(k8sNodeType slim_corev1.NodeAddressType) (nodeAddressing.AddressType, error) {
+	switch k8sNodeType {
+	case slim_corev1.NodeExternalDNS:
+		return nodeAddressing.NodeExternalDNS, nil
+	case slim_corev1.NodeExternalIP:
+		return nodeAddressing.NodeExternalIP, nil
+	case slim_corev1.NodeHostName:
+		return nodeAddressing.NodeHostName, nil
+	case slim_corev1.NodeInternalIP:
+		return nodeAddressing.NodeInternalIP, nil
+	case slim_corev1.NodeInternalDNS:
+		return nodeAddressing.NodeInternalDNS, nil
+	default:
+		return nodeAddressing.AddressType(k8sNodeType), fmt.Errorf("unknown node address type %q", k8sNodeType)
+	}
+}
+
+// ParseNodeAddresses walks the node's Status.Addresses together with the
+// Cilium internal-IP annotations and splits them into the set Cilium can
+// use and the set it can't. An address is rejected, rather than silently
+// dropped, when it fails to parse as an IP or when it's a second address of
+// an (AddressType, family) pair that's already been seen -- e.g. two
+// NodeInternalIPs of the same family. The first one wins; the rest are
+// reported back so callers such as `cilium status` can surface the
+// inconsistency instead of the node looking like it just has one IP.
+func ParseNodeAddresses(k8sNode *slim_corev1.Node) (valid []nodeTypes.Address, invalid []nodeTypes.Address) {
+	type typeAndFamily struct {
+		addrType nodeAddressing.AddressType
+		isIPv4   bool
+	}
+	seen := make(map[typeAndFamily]struct{}, len(k8sNode.Status.Addresses))
+
+	addAddress := func(addrType nodeAddressing.AddressType, raw string) {
+		ip := net.ParseIP(raw)
+		if ip == nil {
+			invalid = append(invalid, nodeTypes.Address{Type: addrType})
+			return
+		}
+
+		tf := typeAndFamily{addrType: addrType, isIPv4: ip.To4() != nil}
+		if _, ok := seen[tf]; ok {
+			invalid = append(invalid, nodeTypes.Address{Type: addrType, IP: ip})
+			return
+		}
+		seen[tf] = struct{}{}
+		valid = append(valid, nodeTypes.Address{Type: addrType, IP: ip})
+	}
+
+	for _, addr := range k8sNode.Status.Addresses {
+		addrType, err := ParseNodeAddressType(addr.Type)
+		if err != nil {
+			log.WithFields(logrus.Fields{
+				logfields.NodeName: k8sNode.Name,
+			}).WithError(err).Warning("Ignoring node address of unknown type")
+			continue
+		}
+		addAddress(addrType, addr.Address)
+	}
+
+	if v, ok := k8sNode.Annotations[annotation.CiliumHostIP]; ok {
+		addAddress(nodeAddressing.NodeCiliumInternalIP, v)
+	}
+	if v, ok := k8sNode.Annotations[annotation.CiliumHostIPv6]; ok {
+		addAddress(nodeAddressing.NodeCiliumInternalIP, v)
+	}
+
+	return valid, invalid
+}
+
+// ParseNode extracts a Cilium node from a Kubernetes node. The source must
+// be the source where the node was learned from.
+func ParseNode(k8sNode *slim_corev1.Node, nodeSource source.Source) *nodeTypes.Node {
+	addrs, invalidAddrs := ParseNodeAddresses(k8sNode)
+
+	newNode := &nodeTypes.Node{
+		Name:             k8sNode.Name,
+		Cluster:          option.Config.ClusterName,
+		IPAddresses:      addrs,
+		InvalidAddresses: invalidAddrs,
+		Labels:           k8sNode.Labels,
+		Source:           nodeSource,
+	}
+
+	// ConsumedAnnotations records exactly the Cilium-managed annotations this
+	// parse actually read, nothing more. When AnnotateK8sNode is false this
+	// holds at most the CiliumHostIP/CiliumHostIPv6 annotations, even if the
+	// node still carries the others from a previous restart; diffing two nodes' ConsumedAnnotations via
+	// PrepareNodeAnnotationsPatch is what lets the reconciler notice "these
+	// are stale now" and strip them instead of leaving them to rot.
+	consumedAnnotations := map[string]string{}
+
+	// CiliumHostIP/CiliumHostIPv6 are read unconditionally above by
+	// ParseNodeAddresses, so they must be tracked unconditionally here too;
+	// otherwise a node that stops carrying one of them would never get a
+	// "remove" op out of PrepareNodeAnnotationsPatch.
+	if v, ok := k8sNode.Annotations[annotation.CiliumHostIP]; ok {
+		consumedAnnotations[annotation.CiliumHostIP] = v
+	}
+	if v, ok := k8sNode.Annotations[annotation.CiliumHostIPv6]; ok {
+		consumedAnnotations[annotation.CiliumHostIPv6] = v
+	}
+
+	// The rest are only consulted when AnnotateK8sNode is set; otherwise any
+	// stale values left over from a previous restart must be ignored rather
+	// than resurrected.
+	var annV4CIDR, annV6CIDR *net.IPNet
+	if option.Config.AnnotateK8sNode {
+		if v, ok := k8sNode.Annotations[annotation.V4CIDRName]; ok {
+			if _, parsed, err := net.ParseCIDR(v); err == nil {
+				annV4CIDR = parsed
+				consumedAnnotations[annotation.V4CIDRName] = v
+			} else {
+				log.WithError(err).WithField(logfields.V4Prefix, v).Warning("Ignoring invalid IPv4 CIDR annotation")
+			}
+		}
+		if v, ok := k8sNode.Annotations[annotation.V6CIDRName]; ok {
+			if _, parsed, err := net.ParseCIDR(v); err == nil {
+				annV6CIDR = parsed
+				consumedAnnotations[annotation.V6CIDRName] = v
+			} else {
+				log.WithError(err).WithField(logfields.V6Prefix, v).Warning("Ignoring invalid IPv6 CIDR annotation")
+			}
+		}
+
+		// The health IPs and WireGuard public key aren't parsed into any
+		// Node field here -- nothing in this tree consumes them yet -- but
+		// they're still Cilium-managed annotations this agent is
+		// responsible for, so they must be tracked the same way the CIDR
+		// annotations are to be reconciled away once stale.
+		for _, key := range []string{annotation.V4HealthName, annotation.V6HealthName, annotation.WireguardPubKey} {
+			if v, ok := k8sNode.Annotations[key]; ok {
+				consumedAnnotations[key] = v
+			}
+		}
+	}
+	newNode.ConsumedAnnotations = consumedAnnotations
+
+	// Spec.PodCIDRs is the dual-stack form; fall back to the legacy
+	// single-CIDR Spec.PodCIDR when it's empty. Either way, whatever the
+	// apiserver assigned here always overrides the annotation for that
+	// family: the annotation only exists to carry the CIDR across restarts
+	// before the apiserver has caught up.
+	podCIDRs := k8sNode.Spec.PodCIDRs
+	if len(podCIDRs) == 0 && k8sNode.Spec.PodCIDR != "" {
+		podCIDRs = []string{k8sNode.Spec.PodCIDR}
+	}
+
+	var specV4CIDR, specV6CIDR *net.IPNet
+	var secondary []*net.IPNet
+	for _, podCIDR := range podCIDRs {
+		_, parsed, err := net.ParseCIDR(podCIDR)
+		if err != nil {
+			log.WithError(err).WithField(logfields.CIDR, podCIDR).Warning("Ignoring invalid PodCIDR")
+			continue
+		}
+
+		if parsed.IP.To4() != nil {
+			if specV4CIDR == nil {
+				specV4CIDR = parsed
+			} else {
+				secondary = append(secondary, parsed)
+			}
+		} else {
+			if specV6CIDR == nil {
+				specV6CIDR = parsed
+			} else {
+				secondary = append(secondary, parsed)
+			}
+		}
+	}
+
+	newNode.IPv4AllocCIDR = annV4CIDR
+	if specV4CIDR != nil {
+		newNode.IPv4AllocCIDR = specV4CIDR
+	}
+	newNode.IPv6AllocCIDR = annV6CIDR
+	if specV6CIDR != nil {
+		newNode.IPv6AllocCIDR = specV6CIDR
+	}
+	newNode.SecondaryAllocCIDRs = secondary
+
+	return newNode
+}
@@ -6,6 +6,9 @@
 package k8s
 
 import (
+	"encoding/json"
+	"reflect"
+	"strings"
 	"testing"
 
 	. "gopkg.in/check.v1"
@@ -89,6 +92,70 @@ func (s *K8sSuite) TestParseNode(c *C) {
 	c.Assert(n.IPv4AllocCIDR.String(), Equals, "10.254.0.0/16")
 	c.Assert(n.IPv6AllocCIDR, NotNil)
 	c.Assert(n.IPv6AllocCIDR.String(), Equals, "f00d:aaaa:bbbb:cccc:dddd:eeee::/112")
+
+	// Dual-stack PodCIDRs takes precedence over both annotations and the
+	// single-CIDR PodCIDR fallback, per family
+	k8sNode = &slim_corev1.Node{
+		ObjectMeta: slim_metav1.ObjectMeta{
+			Name: "node3",
+			Annotations: map[string]string{
+				annotation.V4CIDRName: "10.254.0.0/16",
+				annotation.V6CIDRName: "f00d:aaaa:bbbb:dddd:eeee:ffff::/112",
+			},
+		},
+		Spec: slim_corev1.NodeSpec{
+			PodCIDR:  "10.1.0.0/16",
+			PodCIDRs: []string{"10.2.0.0/16", "f00d:aaaa:bbbb:cccc:dddd:eeee::/112"},
+		},
+	}
+
+	n = ParseNode(k8sNode, source.Local)
+	c.Assert(n.Name, Equals, "node3")
+	c.Assert(n.IPv4AllocCIDR, NotNil)
+	c.Assert(n.IPv4AllocCIDR.String(), Equals, "10.2.0.0/16")
+	c.Assert(n.IPv6AllocCIDR, NotNil)
+	c.Assert(n.IPv6AllocCIDR.String(), Equals, "f00d:aaaa:bbbb:cccc:dddd:eeee::/112")
+	c.Assert(n.SecondaryAllocCIDRs, HasLen, 0)
+
+	// A second CIDR of an already-seen family in PodCIDRs becomes a
+	// secondary allocation CIDR instead of being dropped
+	k8sNode = &slim_corev1.Node{
+		ObjectMeta: slim_metav1.ObjectMeta{
+			Name: "node4",
+		},
+		Spec: slim_corev1.NodeSpec{
+			PodCIDRs: []string{"10.2.0.0/16", "10.3.0.0/16"},
+		},
+	}
+
+	n = ParseNode(k8sNode, source.Local)
+	c.Assert(n.Name, Equals, "node4")
+	c.Assert(n.IPv4AllocCIDR, NotNil)
+	c.Assert(n.IPv4AllocCIDR.String(), Equals, "10.2.0.0/16")
+	c.Assert(n.SecondaryAllocCIDRs, HasLen, 1)
+	c.Assert(n.SecondaryAllocCIDRs[0].String(), Equals, "10.3.0.0/16")
+
+	// A second NodeInternalIP of the same family, and an address that
+	// doesn't parse as an IP at all, are both reported as invalid rather
+	// than silently dropped or overriding the first IP
+	k8sNode = &slim_corev1.Node{
+		ObjectMeta: slim_metav1.ObjectMeta{
+			Name: "node5",
+		},
+		Status: slim_corev1.NodeStatus{
+			Addresses: []slim_corev1.NodeAddress{
+				{Type: slim_corev1.NodeInternalIP, Address: "192.168.1.1"},
+				{Type: slim_corev1.NodeInternalIP, Address: "192.168.1.2"},
+				{Type: slim_corev1.NodeInternalIP, Address: "not-an-ip"},
+				{Type: slim_corev1.NodeExternalIP, Address: "1.2.3.4"},
+			},
+		},
+	}
+
+	n = ParseNode(k8sNode, source.Local)
+	c.Assert(n.Name, Equals, "node5")
+	c.Assert(n.IPAddresses, HasLen, 2)
+	c.Assert(n.InvalidAddresses, HasLen, 2)
 }
 
 func (s *K8sSuite) TestParseNodeWithoutAnnotations(c *C) {
@@ -234,3 +301,98 @@ func Test_ParseNodeAddressType(t *testing.T) {
 		})
 	}
 }
+
+// applyJSONPatch is a minimal RFC 6902 applier covering just the
+// add/replace/remove ops PrepareNodeAnnotationsPatch emits against a flat
+// "/metadata/annotations/<key>" path, enough to round-trip the patch in
+// TestPrepareNodeAnnotationsPatchRoundTrip without pulling in a JSON Patch
+// library.
+func applyJSONPatch(t *testing.T, annotations map[string]string, patch []byte) map[string]string {
+	t.Helper()
+
+	var ops []struct {
+		Op    string `json:"op"`
+		Path  string `json:"path"`
+		Value string `json:"value,omitempty"`
+	}
+	if err := json.Unmarshal(patch, &ops); err != nil {
+		t.Fatalf("unmarshal patch: %v", err)
+	}
+
+	out := make(map[string]string, len(annotations))
+	for k, v := range annotations {
+		out[k] = v
+	}
+
+	const prefix = "/metadata/annotations/"
+	for _, op := range ops {
+		if !strings.HasPrefix(op.Path, prefix) {
+			t.Fatalf("unexpected patch path %q", op.Path)
+		}
+		key := strings.ReplaceAll(strings.TrimPrefix(op.Path, prefix), "~1", "/")
+		key = strings.ReplaceAll(key, "~0", "~")
+
+		switch op.Op {
+		case "add", "replace":
+			out[key] = op.Value
+		case "remove":
+			delete(out, key)
+		default:
+			t.Fatalf("unexpected patch op %q", op.Op)
+		}
+	}
+
+	return out
+}
+
+func TestPrepareNodeAnnotationsPatchRoundTrip(t *testing.T) {
+	prevAnnotateK8sNode := option.Config.AnnotateK8sNode
+	defer func() {
+		option.Config.AnnotateK8sNode = prevAnnotateK8sNode
+	}()
+
+	option.Config.AnnotateK8sNode = true
+	k8sNode := &slim_corev1.Node{
+		ObjectMeta: slim_metav1.ObjectMeta{
+			Name: "node1",
+			Annotations: map[string]string{
+				annotation.V4CIDRName:      "10.1.0.0/16",
+				annotation.V6CIDRName:      "f00d:aaaa:bbbb:cccc:dddd:eeee::/112",
+				annotation.CiliumHostIP:    "10.1.0.1",
+				annotation.V4HealthName:    "10.1.0.2",
+				annotation.WireguardPubKey: "Ot4XAvryXhIH8wHFLE4+HvLnK2Tx8HMWVrUHYoWPLRo=",
+			},
+		},
+	}
+	oldNode := ParseNode(k8sNode, source.Local)
+
+	// Simulate AnnotateK8sNode flipping off between restarts: the new parse
+	// of the same k8s Node no longer consumes the annotations gated on that
+	// flag. CiliumHostIP is read regardless of AnnotateK8sNode, so it's
+	// still consumed and must not be reconciled away.
+	option.Config.AnnotateK8sNode = false
+	newNode := ParseNode(k8sNode, source.Local)
+
+	patch, err := PrepareNodeAnnotationsPatch(oldNode, newNode)
+	if err != nil {
+		t.Fatalf("PrepareNodeAnnotationsPatch: %v", err)
+	}
+	if patch == nil {
+		t.Fatal("expected a non-nil patch removing the stale CIDR/health/WireGuard annotations")
+	}
+
+	reconciled := applyJSONPatch(t, k8sNode.Annotations, patch)
+	want := map[string]string{annotation.CiliumHostIP: "10.1.0.1"}
+	if !reflect.DeepEqual(reconciled, want) {
+		t.Errorf("got %v, want %v left after reconciliation", reconciled, want)
+	}
+
+	// Re-parsing a node built from the reconciled annotations should consume
+	// the same thing newNode did (just CiliumHostIP), and diffing it against
+	// newNode should produce no patch.
+	k8sNode.Annotations = reconciled
+	reparsed := ParseNode(k8sNode, source.Local)
+	if noopPatch, err := PrepareNodeAnnotationsPatch(newNode, reparsed); err != nil || noopPatch != nil {
+		t.Errorf("got patch %s, err %v; want nil, nil once annotations are already reconciled", noopPatch, err)
+	}
+}
@@ -0,0 +1,31 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright Authors of Cilium
+
+package logging
+
+import (
+	"context"
+
+	"github.com/sirupsen/logrus"
+)
+
+type loggerCtxKey struct{}
+
+// NewContext returns a copy of ctx that carries logger. Downstream code
+// reached through ctx can retrieve it with FromContext, which is how a
+// bootstrap path attaches per-node fields (node name, cluster ID, agent
+// UUID) once and has every call it makes inherit them, following the
+// contextual-logging pattern kubelet uses.
+func NewContext(ctx context.Context, logger logrus.FieldLogger) context.Context {
+	return context.WithValue(ctx, loggerCtxKey{}, logger)
+}
+
+// FromContext returns the logger attached to ctx via NewContext. If none was
+// attached, it falls back to DefaultLogger so that call sites which have not
+// yet been migrated to contextual logging keep working unchanged.
+func FromContext(ctx context.Context) logrus.FieldLogger {
+	if logger, ok := ctx.Value(loggerCtxKey{}).(logrus.FieldLogger); ok {
+		return logger
+	}
+	return DefaultLogger
+}
@@ -0,0 +1,38 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright Authors of Cilium
+
+package node
+
+import (
+	"net"
+	"sync"
+)
+
+type poolRanges struct {
+	V4 []*net.IPNet
+	V6 []*net.IPNet
+}
+
+var (
+	allocRangesByPoolMu sync.RWMutex
+	allocRangesByPool    = map[string]poolRanges{}
+)
+
+// SetAllocRangesByPool records the v4/v6 CIDRs the ClusterPool v2 allocator
+// assigned to poolName, so that IPAM allocators for that pool can look up
+// their range without re-parsing the CiliumNode spec. Calling it again for
+// the same poolName replaces the previously recorded ranges.
+func SetAllocRangesByPool(poolName string, v4CIDRs, v6CIDRs []*net.IPNet) {
+	allocRangesByPoolMu.Lock()
+	defer allocRangesByPoolMu.Unlock()
+	allocRangesByPool[poolName] = poolRanges{V4: v4CIDRs, V6: v6CIDRs}
+}
+
+// GetAllocRangesByPool returns the v4/v6 CIDRs previously recorded for
+// poolName via SetAllocRangesByPool.
+func GetAllocRangesByPool(poolName string) (v4CIDRs, v6CIDRs []*net.IPNet) {
+	allocRangesByPoolMu.RLock()
+	defer allocRangesByPoolMu.RUnlock()
+	r := allocRangesByPool[poolName]
+	return r.V4, r.V6
+}
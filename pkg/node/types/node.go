@@ -0,0 +1,171 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright Authors of Cilium
+
+// Package types holds the subset of pkg/node/types.Node that pkg/k8s
+// populates from Kubernetes Node/CiliumNode resources and reads back.
+package types
+
+import (
+	"net"
+	"os"
+
+	ciliumv2 "github.com/cilium/cilium/pkg/k8s/apis/cilium.io/v2"
+	k8sConst "github.com/cilium/cilium/pkg/k8s/constants"
+	nodeAddressing "github.com/cilium/cilium/pkg/node/addressing"
+	"github.com/cilium/cilium/pkg/option"
+	"github.com/cilium/cilium/pkg/source"
+)
+
+// Address is a node address with its type (internal, external, ...) and
+// address family.
+type Address struct {
+	Type nodeAddressing.AddressType
+	IP   net.IP
+}
+
+// Node contains the fields pkg/k8s.ParseNode/ParseCiliumNode populate and
+// the rest of the agent reads back to configure IPAM and datapath state.
+type Node struct {
+	// Name is the name of the node. This is typically the hostname of
+	// the node.
+	Name string
+
+	// Cluster is the name of the cluster the node is associated with.
+	Cluster string
+
+	// IPAddresses is the list of all node addresses.
+	IPAddresses []Address
+
+	// InvalidAddresses holds addresses ParseNodeAddresses rejected instead
+	// of silently dropping: ones that failed to parse as an IP, or a
+	// second address of an (AddressType, family) pair already seen in
+	// IPAddresses.
+	InvalidAddresses []Address
+
+	// Labels is the list of labels of the node.
+	Labels map[string]string
+
+	// Source is the source where the node was learned from.
+	Source source.Source
+
+	// IPv4AllocCIDR is the IPv4 allocation CIDR assigned to this node.
+	IPv4AllocCIDR *net.IPNet
+
+	// IPv6AllocCIDR is the IPv6 allocation CIDR assigned to this node.
+	IPv6AllocCIDR *net.IPNet
+
+	// SecondaryAllocCIDRs holds any additional per-family PodCIDRs beyond
+	// IPv4AllocCIDR/IPv6AllocCIDR, for nodes that have more than one range
+	// allocated per family.
+	SecondaryAllocCIDRs []*net.IPNet
+
+	// ConsumedAnnotations records exactly the Cilium-managed annotations
+	// this parse actually read from the k8s Node, nothing more. Diffing
+	// two nodes' ConsumedAnnotations via PrepareNodeAnnotationsPatch is
+	// what lets the reconciler notice which annotations are now stale and
+	// strip them instead of leaving them to rot.
+	ConsumedAnnotations map[string]string
+}
+
+// GetName returns the name of the local node, as derived from the
+// environment variable Kubernetes sets on every kubelet-managed Pod,
+// falling back to the machine hostname. The environment variable takes
+// precedence so it can override whatever name auto-detection would
+// otherwise pick.
+func GetName() string {
+	if name := os.Getenv(k8sConst.EnvNodeNameSpec); name != "" {
+		return name
+	}
+	hostname, _ := os.Hostname()
+	return hostname
+}
+
+// ParseCiliumNode parses a CiliumNode custom resource into a Node. Unlike
+// ParseNode, the CIDRs and addresses always come from the CRD's Spec rather
+// than Status/annotations, since that's the only place cluster-pool and
+// cluster-pool-v2 IPAM write them.
+func ParseCiliumNode(n *ciliumv2.CiliumNode) Node {
+	newNode := Node{
+		Name:    n.Name,
+		Cluster: option.Config.ClusterName,
+		Source:  source.CustomResource,
+	}
+
+	for _, addr := range n.Spec.Addresses {
+		ip := net.ParseIP(addr.IP)
+		if ip == nil {
+			continue
+		}
+		newNode.IPAddresses = append(newNode.IPAddresses, Address{Type: addr.Type, IP: ip})
+	}
+
+	var secondary []*net.IPNet
+	for _, podCIDR := range n.Spec.IPAM.PodCIDRs {
+		_, parsed, err := net.ParseCIDR(podCIDR)
+		if err != nil {
+			continue
+		}
+
+		if parsed.IP.To4() != nil {
+			if newNode.IPv4AllocCIDR == nil {
+				newNode.IPv4AllocCIDR = parsed
+			} else {
+				secondary = append(secondary, parsed)
+			}
+		} else {
+			if newNode.IPv6AllocCIDR == nil {
+				newNode.IPv6AllocCIDR = parsed
+			} else {
+				secondary = append(secondary, parsed)
+			}
+		}
+	}
+	newNode.SecondaryAllocCIDRs = secondary
+
+	return newNode
+}
+
+// firstAddress returns the IP of the first address of the requested family
+// matching one of types, trying each type in order before moving on to the
+// next.
+func (n *Node) firstAddress(ipv6 bool, types ...nodeAddressing.AddressType) net.IP {
+	for _, t := range types {
+		for _, addr := range n.IPAddresses {
+			if addr.Type != t {
+				continue
+			}
+			if (addr.IP.To4() == nil) != ipv6 {
+				continue
+			}
+			return addr.IP
+		}
+	}
+	return nil
+}
+
+// GetNodeIP returns one of the node's addresses for the requested family,
+// preferring a NodeInternalIP over a NodeExternalIP.
+func (n *Node) GetNodeIP(ipv6 bool) net.IP {
+	return n.firstAddress(ipv6, nodeAddressing.NodeInternalIP, nodeAddressing.NodeExternalIP)
+}
+
+// GetK8sNodeIP returns the IPv4 node IP if available, falling back to IPv6.
+// This is the address BPF NodePort device auto-detection keys off of.
+func (n *Node) GetK8sNodeIP() net.IP {
+	if ip := n.GetNodeIP(false); ip != nil {
+		return ip
+	}
+	return n.GetNodeIP(true)
+}
+
+// GetExternalIP returns the node's NodeExternalIP for the requested family,
+// if any.
+func (n *Node) GetExternalIP(ipv6 bool) net.IP {
+	return n.firstAddress(ipv6, nodeAddressing.NodeExternalIP)
+}
+
+// GetCiliumInternalIP returns the node's Cilium-internal (router) IP for the
+// requested family, if any.
+func (n *Node) GetCiliumInternalIP(ipv6 bool) net.IP {
+	return n.firstAddress(ipv6, nodeAddressing.NodeCiliumInternalIP)
+}
@@ -0,0 +1,83 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright Authors of Cilium
+
+// Package option exposes the cilium-agent's runtime configuration, built up
+// from CLI flags and the config file. Only the knobs consumed by the
+// packages in this tree are declared here.
+package option
+
+import (
+	"time"
+
+	ipamOption "github.com/cilium/cilium/pkg/ipam/option"
+)
+
+// DaemonConfig is the configuration used by cilium-agent to configure and
+// run the various subsystems of the agent.
+type DaemonConfig struct {
+	// AnnotateK8sNode enables annotating the k8s Node resource with the
+	// CIDRs and addresses Cilium has allocated for it.
+	AnnotateK8sNode bool
+
+	// ClusterName is the name of the cluster this agent belongs to.
+	ClusterName string
+
+	// ClusterPoolIPv4CIDR and ClusterPoolIPv6CIDR are the cluster-scoped
+	// CIDRs cluster-pool IPAM carves per-node PodCIDRs out of.
+	ClusterPoolIPv4CIDR     string
+	ClusterPoolIPv4MaskSize int
+	ClusterPoolIPv6CIDR     string
+	ClusterPoolIPv6MaskSize int
+
+	// CloudAliasProvider selects the pkg/ipam/cloudalias.Provider used
+	// when IPAM is IPAMCloudAlias, e.g. "gce" or "aws".
+	CloudAliasProvider string
+
+	// Devices is the list of native devices the user explicitly
+	// configured; when empty, the datapath falls back to auto-detection.
+	Devices []string
+
+	// EnableAgentNodeCIDRAllocation enables the in-agent range CIDR
+	// allocator (pkg/ipam/rangeallocator), for cluster-pool deployments
+	// that have neither kube-controller-manager's --allocate-node-cidrs
+	// nor cilium-operator available to hand out PodCIDRs.
+	EnableAgentNodeCIDRAllocation bool
+
+	// EnableHostIPRestore restores the router IPs from the Kubernetes
+	// Node/CiliumNode resource on restart.
+	EnableHostIPRestore bool
+
+	EnableIPv4 bool
+	EnableIPv6 bool
+
+	// IPAM selects the IP address management backend in use.
+	IPAM ipamOption.IPAMMode
+
+	// IPAMMultiPoolPreAllocation maps each cluster-pool v2 pool name this
+	// node must have allocated to the number of addresses/CIDRs to
+	// pre-allocate from it.
+	IPAMMultiPoolPreAllocation map[string]string
+
+	// IPAllocationTimeout bounds a single identity allocation attempt for
+	// a CIDR prefix.
+	IPAllocationTimeout time.Duration
+
+	// K8sHeartbeatTimeout is the maximum time to wait for a successful
+	// heartbeat against the kube-apiserver before restarting connections.
+	K8sHeartbeatTimeout time.Duration
+
+	// K8sRequireIPv4PodCIDR and K8sRequireIPv6PodCIDR make startup fail
+	// fast if the corresponding family's PodCIDR cannot be retrieved.
+	K8sRequireIPv4PodCIDR bool
+	K8sRequireIPv6PodCIDR bool
+}
+
+// Config is the global configuration of the agent.
+var Config = &DaemonConfig{}
+
+// MightAutoDetectDevices returns true if the datapath may need to
+// auto-detect its native devices, which is the case whenever the user
+// hasn't explicitly configured any.
+func MightAutoDetectDevices() bool {
+	return len(Config.Devices) == 0
+}